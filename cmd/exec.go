@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/utils"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec <task-id> -- <command> [args...]",
+	Short: "Run a command in a running task.",
+	Long: `cube exec command.
+
+The exec command runs a command inside the container of a running task. The
+session is relayed by the manager to the worker that runs the task.
+Use -i to send stdin to the command and -t to allocate a tty for it, -c to run
+it in another container of a task group. With -i and -t the terminal is put in
+raw mode for the session and restored when the command exits; the command's
+tty gets the terminal's size when it starts.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		tty, _ := cmd.Flags().GetBool("tty")
+		container, _ := cmd.Flags().GetString("container")
+
+		q := url.Values{}
+		q["cmd"] = args[1:]
+		if container != "" {
+			q.Set("container", container)
+		}
+		if interactive {
+			q.Set("stdin", "true")
+		}
+		if tty {
+			q.Set("tty", "true")
+			// the command's tty starts with the size of ours
+			if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+				q.Set("h", strconv.Itoa(h))
+				q.Set("w", strconv.Itoa(w))
+			}
+		}
+
+		path := fmt.Sprintf("/tasks/%s/exec?%s", args[0], q.Encode())
+		conn, err := utils.DialUpgrade(manager, path)
+		if err != nil {
+			log.Fatalf("Error executing command in task %v: %v", args[0], err)
+		}
+		defer conn.Close()
+
+		// keystrokes go to the command's tty as they are typed, it does the
+		// echoing and line editing
+		fd := int(os.Stdin.Fd())
+		if tty && interactive && term.IsTerminal(fd) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				log.Fatalf("Error putting the terminal in raw mode: %v", err)
+			}
+			defer term.Restore(fd, state)
+		}
+
+		go func() {
+			if interactive {
+				io.Copy(conn, os.Stdin)
+			}
+			utils.CloseWrite(conn)
+		}()
+
+		// without a tty stdout and stderr arrive multiplexed on the same stream
+		if tty {
+			io.Copy(os.Stdout, conn)
+		} else {
+			stdcopy.StdCopy(os.Stdout, os.Stderr, conn)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	execCmd.Flags().BoolP("interactive", "i", false, "Keep stdin open and send it to the command")
+	execCmd.Flags().BoolP("tty", "t", false, "Allocate a tty for the command")
+	execCmd.Flags().StringP("container", "c", "", "Container of a task group to run the command in")
+}
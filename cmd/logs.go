@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs <task-id>",
+	Short: "Print the logs of a task.",
+	Long: `cube logs command.
+
+The logs command prints the output of a task, fetched through the manager from
+the worker that runs it. Use -f to keep streaming new output and -c to read
+another container of a task group.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		follow, _ := cmd.Flags().GetBool("follow")
+		tail, _ := cmd.Flags().GetString("tail")
+		since, _ := cmd.Flags().GetString("since")
+		container, _ := cmd.Flags().GetString("container")
+
+		q := url.Values{}
+		if follow {
+			q.Set("follow", "true")
+		}
+		if tail != "" {
+			q.Set("tail", tail)
+		}
+		if since != "" {
+			q.Set("since", since)
+		}
+		if container != "" {
+			q.Set("container", container)
+		}
+
+		u := fmt.Sprintf("http://%s/tasks/%s/logs?%s", manager, args[0], q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", u, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("Error getting logs for task %v (%d): %s", args[0], resp.StatusCode, body)
+		}
+
+		io.Copy(os.Stdout, resp.Body)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().String("tail", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().StringP("container", "c", "", "Container of a task group to read")
+	logsCmd.Flags().String("since", "", "Show logs since a timestamp or relative duration (e.g. 10m)")
+}
@@ -5,8 +5,12 @@ package cmd
 
 import (
 	"cube/manager"
+	"cube/node"
+	"cube/task"
 	"cube/worker"
+	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/spf13/cobra"
 )
@@ -28,15 +32,49 @@ The manager controls the orchestration system and is responsible for:
 		workers, _ := cmd.Flags().GetStringSlice("workers")
 		scheduler, _ := cmd.Flags().GetString("scheduler")
 		dbType, _ := cmd.Flags().GetString("dbType")
+		secretsKeyFile, _ := cmd.Flags().GetString("secrets-key-file")
+		portRange, _ := cmd.Flags().GetString("port-range")
+		dnsPort, _ := cmd.Flags().GetInt("dns-port")
+		proxyPort, _ := cmd.Flags().GetInt("proxy-port")
+		minPort, maxPort, err := node.ParsePortRange(portRange)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		log.Println("Starting manager.")
 		go worker.ServeWorkersByAddressWithApi(workers, dbType)
 		m := manager.New(workers, scheduler, dbType)
+		m.SetPortRange(minPort, maxPort)
+		if key, err := task.LoadSecretsKey(secretsKeyFile); err != nil && secretsKeyFile != "" {
+			log.Fatalf("unable to load secrets key: %v", err)
+		} else if err != nil {
+			log.Printf("Secrets disabled: %v", err)
+		} else if err := m.SetSecretsKey(key); err != nil {
+			log.Fatalf("invalid secrets key: %v", err)
+		}
+		m.RestoreTasks()
 		api := manager.Api{Address: host, Port: port, Manager: m}
 		go m.ProcessTasks()
 		go m.UpdateTasks()
 		go m.DoHealthChecks()
 		go m.UpdateNodeStats()
+		go m.ProcessCronJobs()
+		go m.ReconcileServices()
+		if dnsPort != 0 {
+			go func() {
+				if err := m.ServeDNS(fmt.Sprintf("%s:%d", host, dnsPort)); err != nil {
+					log.Printf("DNS server stopped: %v", err)
+				}
+			}()
+		}
+		if proxyPort != 0 {
+			go func() {
+				log.Printf("Starting proxy on http://%s:%d", host, proxyPort)
+				if err := http.ListenAndServe(fmt.Sprintf("%s:%d", host, proxyPort), manager.NewProxy(m)); err != nil {
+					log.Printf("Proxy stopped: %v", err)
+				}
+			}()
+		}
 		log.Printf("Starting manager API on http://%s:%d", host, port)
 		api.Start()
 	},
@@ -75,4 +113,24 @@ func init() {
 		"memory",
 		"Type of datastore to use for events and tasks (\"memory\" or \"persistent\")",
 	)
+	managerCmd.Flags().Int(
+		"dns-port",
+		5553,
+		"UDP port of the DNS server answering for <task>.cube and <service>.cube, 0 to disable it",
+	)
+	managerCmd.Flags().Int(
+		"proxy-port",
+		8080,
+		"Port of the reverse proxy sending requests to tasks along the manager's routes, 0 to disable it",
+	)
+	managerCmd.Flags().String(
+		"port-range",
+		fmt.Sprintf("%d-%d", node.DefaultMinPort, node.DefaultMaxPort),
+		"Range of host ports allocated to the ports tasks publish on each worker",
+	)
+	managerCmd.Flags().String(
+		"secrets-key-file",
+		"",
+		"File holding the base64 encoded 32 byte key secrets are encrypted with (defaults to $"+task.SecretsKeyEnv+")",
+	)
 }
@@ -0,0 +1,53 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <service>",
+	Short: "Roll a service back to its previous revision.",
+	Long: `cube rollback command.
+
+The rollback command restores the previous task template of a service and rolls
+it out the same way as an update. Rolling back twice returns to the revision
+the first rollback replaced. It also resumes an update that was paused.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/services/%s/rollback", manager, args[0])
+		resp, err := http.Post(url, "application/json", nil)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error rolling back service %s (%d): %s", args[0], resp.StatusCode, body)
+		}
+
+		var s task.Service
+		if err := json.Unmarshal(body, &s); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Service %s is rolling back to revision %d.", s.Name, s.Revision)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// scaleCmd represents the scale command
+var scaleCmd = &cobra.Command{
+	Use:   "scale <service> <replicas>",
+	Short: "Change the number of replicas of a service.",
+	Long: `cube scale command.
+
+The scale command sets the desired number of tasks of a service. The manager
+starts or stops tasks until that many are running.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		replicas, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("Invalid replica count %q", args[1])
+		}
+
+		data, _ := json.Marshal(map[string]int{"Replicas": replicas})
+		url := fmt.Sprintf("http://%s/services/%s/scale", manager, args[0])
+		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("Error scaling service %s (%d): %s", args[0], resp.StatusCode, body)
+		}
+		log.Printf("Service %s scaled to %d replicas.", args[0], replicas)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+
+	scaleCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
@@ -43,7 +43,7 @@ The status command allows a user to get the status of tasks from the Cube manage
 		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tCONTAINERNAME\tIMAGE\t")
+		fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tREASON\tREADY\tCONTAINERNAME\tIMAGE\t")
 		for _, task := range tasks {
 			var start string
 			if task.StartTime.IsZero() {
@@ -52,8 +52,12 @@ The status command allows a user to get the status of tasks from the Cube manage
 				start = fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(task.StartTime)))
 			}
 
-			state := task.State.String()[task.State]
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", task.ID, task.Name, start, state, task.Name, task.Image)
+			state := task.State.String()
+			var reason string
+			if tr := task.LastTransition(); tr != nil {
+				reason = tr.Reason
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\t\n", task.ID, task.Name, start, state, reason, task.Ready, task.Name, task.Image)
 		}
 		w.Flush()
 	},
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"log"
+	"net"
 
 	"github.com/spf13/cobra"
 )
@@ -24,13 +25,21 @@ var workerCmd = &cobra.Command{
 		port, _ := cmd.Flags().GetInt("port")
 		name, _ := cmd.Flags().GetString("name")
 		dbType, _ := cmd.Flags().GetString("dbtype")
+		bindPaths, _ := cmd.Flags().GetStringSlice("bind-paths")
+		advertise, _ := cmd.Flags().GetString("advertise-address")
+		if advertise != "" && net.ParseIP(advertise) == nil {
+			log.Fatalf("invalid advertise address %q", advertise)
+		}
 
 		log.Println("Starting worker.")
 		w := worker.New(name, dbType)
+		w.BindPaths = bindPaths
+		w.Address = advertise
 		api := worker.Api{Address: host, Port: port, Worker: w}
 		go w.RunTasks()
 		go w.CollectStats()
 		go w.UpdateTasks()
+		go w.RunProbes()
 		log.Printf("Starting worker API on http://%s:%d", host, port)
 		api.Start()
 	},
@@ -63,4 +72,14 @@ func init() {
 		"memory",
 		"Type of datastore to use for tasks (\"memory\" or \"persistent\")",
 	)
+	workerCmd.Flags().StringSlice(
+		"bind-paths",
+		[]string{},
+		"Host paths (and their subdirectories) that tasks are allowed to bind mount",
+	)
+	workerCmd.Flags().String(
+		"advertise-address",
+		"",
+		"IP address the manager's DNS server and proxy reach this worker's tasks at, instead of the address of its API",
+	)
 }
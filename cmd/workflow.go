@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/manager"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// workflowCmd represents the workflow command
+var workflowCmd = &cobra.Command{
+	Use:   "workflow",
+	Short: "Workflow commands to run dependent tasks.",
+	Long: `cube workflow command.
+
+A workflow is a set of batch tasks where a task only starts once the tasks it
+depends on have completed.`,
+}
+
+var workflowRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Submit a workflow to the manager.",
+	Run: func(cmd *cobra.Command, args []string) {
+		managerAddr, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Unable to read file: %v", filename)
+		}
+
+		url := fmt.Sprintf("http://%s/workflows", managerAddr)
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error submitting workflow (%d): %s", resp.StatusCode, body)
+		}
+		log.Printf("Successfully submitted workflow: %s", body)
+	},
+}
+
+var workflowStatusCmd = &cobra.Command{
+	Use:   "status [workflow-id]",
+	Short: "List workflows, or the steps of one workflow.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		managerAddr, _ := cmd.Flags().GetString("manager")
+
+		url := fmt.Sprintf("http://%s/workflows", managerAddr)
+		if len(args) == 1 {
+			url = fmt.Sprintf("%s/%s", url, args[0])
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error getting workflows (%d): %s", resp.StatusCode, body)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		defer w.Flush()
+
+		if len(args) == 0 {
+			var workflows []*manager.Workflow
+			if err := json.Unmarshal(body, &workflows); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintln(w, "ID\tNAME\tSTATE\tSTEPS\t")
+			for _, wf := range workflows {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t\n", wf.ID, wf.Name, wf.State, len(wf.Steps))
+			}
+			return
+		}
+
+		var wf manager.Workflow
+		if err := json.Unmarshal(body, &wf); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintln(w, "STEP\tSTATE\tATTEMPTS\tTASK\tMESSAGE\t")
+		for _, s := range wf.Steps {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t\n", s.Name, s.State, s.Attempts, s.TaskID, s.Message)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(workflowCmd)
+	workflowCmd.AddCommand(workflowRunCmd)
+	workflowCmd.AddCommand(workflowStatusCmd)
+
+	workflowCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	workflowRunCmd.Flags().StringP("filename", "f", "workflow.json", "Workflow specification file")
+}
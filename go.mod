@@ -11,16 +11,18 @@ require (
 )
 
 require (
+	github.com/boltdb/bolt v1.3.1
+	github.com/docker/go-units v0.4.0
 	github.com/shirou/gopsutil v3.21.11+incompatible
-	google.golang.org/grpc v1.37.1
+	github.com/spf13/cobra v1.8.1
+	golang.org/x/net v0.0.0-20210510120150-4163338589ed
+	golang.org/x/term v0.19.0
 )
 
 require (
 	github.com/Microsoft/go-winio v0.5.0 // indirect
-	github.com/boltdb/bolt v1.3.1 // indirect
 	github.com/containerd/containerd v1.5.1 // indirect
 	github.com/docker/distribution v2.7.1+incompatible // indirect
-	github.com/docker/go-units v0.4.0 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.4.3 // indirect
@@ -30,14 +32,12 @@ require (
 	github.com/opencontainers/image-spec v1.0.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sirupsen/logrus v1.8.1 // indirect
-	github.com/spf13/cobra v1.8.1 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tklauser/go-sysconf v0.3.14 // indirect
 	github.com/tklauser/numcpus v0.8.0 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	golang.org/x/net v0.0.0-20210510120150-4163338589ed // indirect
 	golang.org/x/sys v0.19.0 // indirect
-	golang.org/x/text v0.3.6 // indirect
 	google.golang.org/genproto v0.0.0-20201110150050-8816d57aaa9a // indirect
+	google.golang.org/grpc v1.37.1 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
 )
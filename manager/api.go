@@ -25,8 +25,60 @@ func (a *Api) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
 		})
 	})
+	a.Router.Route("/workflows", func(r chi.Router) {
+		r.Post("/", a.AddWorkflowHandler)
+		r.Get("/", a.GetWorkflowsHandler)
+		r.Route("/{workflowID}", func(r chi.Router) {
+			r.Get("/", a.GetWorkflowHandler)
+			r.Delete("/", a.CancelWorkflowHandler)
+		})
+	})
+	a.Router.Route("/cronjobs", func(r chi.Router) {
+		r.Post("/", a.AddCronJobHandler)
+		r.Get("/", a.GetCronJobsHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Get("/", a.GetCronJobHandler)
+			r.Delete("/", a.DeleteCronJobHandler)
+		})
+	})
+	a.Router.Route("/services", func(r chi.Router) {
+		r.Post("/", a.AddServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Put("/", a.UpdateServiceHandler)
+			r.Delete("/", a.DeleteServiceHandler)
+			r.Put("/scale", a.ScaleServiceHandler)
+			r.Post("/rollback", a.RollbackServiceHandler)
+		})
+	})
+	a.Router.Route("/registries", func(r chi.Router) {
+		r.Post("/", a.AddRegistryHandler)
+		r.Get("/", a.GetRegistriesHandler)
+		r.Delete("/{server}", a.RemoveRegistryHandler)
+	})
+	a.Router.Route("/secrets", func(r chi.Router) {
+		r.Post("/", a.AddSecretHandler)
+		r.Get("/", a.GetSecretsHandler)
+		r.Delete("/{name}", a.DeleteSecretHandler)
+	})
+	a.Router.Route("/configs", func(r chi.Router) {
+		r.Post("/", a.AddConfigHandler)
+		r.Get("/", a.GetConfigsHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Get("/", a.GetConfigHandler)
+			r.Delete("/", a.DeleteConfigHandler)
+		})
+	})
+	a.Router.Route("/routes", func(r chi.Router) {
+		r.Post("/", a.AddRouteHandler)
+		r.Get("/", a.GetRoutesHandler)
+		r.Delete("/{name}", a.DeleteRouteHandler)
+	})
 	a.Router.Route("/nodes", func(r chi.Router) {
 		r.Get("/", a.GetNodesHandler)
 	})
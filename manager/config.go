@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"log"
+)
+
+// AddConfig stores data as the next version of the named config, creating the
+// config if needed. The running tasks that follow its latest version are rolled
+// by the health check loop, and tasks of services by the next reconciliation of
+// their service.
+func (m *Manager) AddConfig(name string, data string) (*task.AppConfig, error) {
+	if !task.ValidConfigName(name) {
+		return nil, fmt.Errorf("invalid config name %q", name)
+	}
+
+	m.configsMu.Lock()
+	c := &task.AppConfig{Name: name}
+	if result, err := m.ConfigDb.Get(name); err == nil {
+		c = result.(*task.AppConfig)
+	}
+	cv, err := c.Add(data)
+	if err == nil {
+		err = m.ConfigDb.Put(name, c)
+	}
+	config := *c
+	m.configsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Stored version %d of config %s\n", cv.Version, name)
+	return &config, nil
+}
+
+func (m *Manager) GetConfigs() []*task.AppConfig {
+	m.configsMu.Lock()
+	defer m.configsMu.Unlock()
+	result, err := m.ConfigDb.List()
+	if err != nil {
+		log.Printf("Error getting list of configs: %v\n", err)
+		return nil
+	}
+	var configs []*task.AppConfig
+	for _, c := range result.([]*task.AppConfig) {
+		config := *c
+		configs = append(configs, &config)
+	}
+	return configs
+}
+
+func (m *Manager) GetConfig(name string) (*task.AppConfig, error) {
+	m.configsMu.Lock()
+	defer m.configsMu.Unlock()
+	result, err := m.ConfigDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	config := *result.(*task.AppConfig)
+	return &config, nil
+}
+
+// DeleteConfig removes the config with all its versions. Running tasks keep
+// their files, but a task referencing the config fails when it is started again.
+func (m *Manager) DeleteConfig(name string) error {
+	m.configsMu.Lock()
+	defer m.configsMu.Unlock()
+	if _, err := m.ConfigDb.Get(name); err != nil {
+		return err
+	}
+	return m.ConfigDb.Delete(name)
+}
+
+// latestConfigVersions maps the name of every config to its latest version.
+func (m *Manager) latestConfigVersions() map[string]int {
+	m.configsMu.Lock()
+	defer m.configsMu.Unlock()
+	latest := make(map[string]int)
+	result, err := m.ConfigDb.List()
+	if err != nil {
+		log.Printf("Error getting list of configs: %v\n", err)
+		return latest
+	}
+	for _, c := range result.([]*task.AppConfig) {
+		latest[c.Name] = c.Latest().Version
+	}
+	return latest
+}
+
+// withConfigs returns a copy of the event carrying the data of the configs the
+// task references, in the version the task asks for, and records those versions
+// in the task.
+func (m *Manager) withConfigs(te task.TaskEvent) (task.TaskEvent, error) {
+	if len(te.Task.Configs) == 0 {
+		return te, nil
+	}
+
+	m.configsMu.Lock()
+	defer m.configsMu.Unlock()
+	data := make(map[string]string)
+	versions := make(map[string]int)
+	for _, ref := range te.Task.Configs {
+		result, err := m.ConfigDb.Get(ref.Name)
+		if err != nil {
+			return te, fmt.Errorf("config %s not found", ref.Name)
+		}
+		cv, err := result.(*task.AppConfig).Version(ref.Version)
+		if err != nil {
+			return te, err
+		}
+		data[ref.Name] = cv.Data
+		versions[ref.Name] = cv.Version
+	}
+	te.Configs = data
+	te.Task.ConfigVersions = versions
+	return te, nil
+}
+
+// rollConfigTasks rolls a running task, other than those of services, that
+// follows the latest version of a config but runs an older one. Tasks are rolled
+// one at a time: the next one waits until the previous one is ready again.
+func (m *Manager) rollConfigTasks() {
+	latest := m.latestConfigVersions()
+	var next *task.Task
+	var name string
+	for _, t := range m.GetTasks() {
+		if t.Labels[task.LabelService] != "" {
+			continue
+		}
+		if configRolling(t) {
+			log.Printf("Task %s is still rolling to a new config version\n", t.ID)
+			return
+		}
+		if next != nil || t.State != task.Running {
+			continue
+		}
+		if n, outdated := task.ConfigOutdated(t, latest); outdated {
+			next, name = t, n
+		}
+	}
+	if next == nil {
+		return
+	}
+	log.Printf("Rolling task %s to version %d of config %s\n", next.ID, latest[name], name)
+	m.rollTask(next, task.ReasonConfigChanged, fmt.Sprintf("config %s changed to version %d", name, latest[name]))
+}
+
+// configRolling reports whether the task was restarted for a config change and
+// is not ready again yet.
+func configRolling(t *task.Task) bool {
+	n := len(t.Transitions)
+	if n == 0 {
+		return false
+	}
+	last := t.Transitions[n-1]
+	if t.State == task.Restarting {
+		return last.Reason == task.ReasonConfigChanged
+	}
+	return t.State == task.Running && !t.Ready && n > 1 &&
+		t.Transitions[n-2].To == task.Restarting && t.Transitions[n-2].Reason == task.ReasonConfigChanged
+}
@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRollConfigTasksOneAtATime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		te := task.TaskEvent{}
+		json.NewDecoder(r.Body).Decode(&te)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(te.Task)
+	}))
+	defer srv.Close()
+	worker := strings.TrimPrefix(srv.URL, "http://")
+
+	m := New([]string{worker}, "roundrobin", "memory")
+	if _, err := m.AddConfig("app", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	var ids []uuid.UUID
+	for i := 0; i < 2; i++ {
+		tk := &task.Task{
+			ID:             uuid.New(),
+			Name:           "app",
+			State:          task.Running,
+			Ready:          true,
+			Configs:        []task.ConfigRef{{Name: "app", File: "/etc/app.conf"}},
+			ConfigVersions: map[string]int{"app": 1},
+		}
+		m.TaskDb.Put(tk.ID.String(), tk)
+		m.assignTask(tk.ID, worker)
+		ids = append(ids, tk.ID)
+	}
+
+	if _, err := m.AddConfig("app", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countState(m, task.Restarting); n != 0 {
+		t.Fatalf("AddConfig restarted %d tasks, want them left to the health check loop", n)
+	}
+
+	m.rollConfigTasks()
+	m.rollConfigTasks()
+	if n := countState(m, task.Restarting); n != 1 {
+		t.Fatalf("%d tasks are restarting, want 1", n)
+	}
+
+	// the worker reports the rolled task running and ready again
+	for _, id := range ids {
+		result, _ := m.TaskDb.Get(id.String())
+		tk := result.(*task.Task)
+		if tk.State == task.Restarting {
+			if got := tk.ConfigVersions["app"]; got != 2 {
+				t.Errorf("rolled task runs version %d of the config, want 2", got)
+			}
+			m.StateMachine.Transition(tk, task.Running, task.ReasonStarted, "")
+			tk.UpdateReady()
+			m.TaskDb.Put(id.String(), tk)
+		}
+	}
+
+	m.rollConfigTasks()
+	if n := countState(m, task.Restarting); n != 1 {
+		t.Fatalf("%d tasks are restarting, want the second one", n)
+	}
+	m.rollConfigTasks()
+	if n := countState(m, task.Restarting); n != 1 {
+		t.Fatalf("%d tasks are restarting after both were rolled, want 1", n)
+	}
+}
+
+func countState(m *Manager, s task.State) int {
+	n := 0
+	for _, t := range m.GetTasks() {
+		if t.State == s {
+			n++
+		}
+	}
+	return n
+}
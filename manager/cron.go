@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+// AddCronJob stores a new cron job. Its first run is the first scheduled time
+// after it was added.
+func (m *Manager) AddCronJob(c *task.CronJob) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.Task.Kind == "" {
+		c.Task.Kind = task.KindBatch
+	}
+	if err := m.CheckResources(c.Task); err != nil {
+		return fmt.Errorf("task cannot be enforced on any worker: %v", err)
+	}
+
+	m.cronJobsMu.Lock()
+	defer m.cronJobsMu.Unlock()
+	if _, err := m.CronJobDb.Get(c.Name); err == nil {
+		return fmt.Errorf("cron job %s already exists", c.Name)
+	}
+	c.CreationTime = time.Now()
+	c.LastScheduleTime = time.Time{}
+	c.Active = nil
+	c.History = nil
+	c.MissedRuns = 0
+	return m.CronJobDb.Put(c.Name, c)
+}
+
+func (m *Manager) GetCronJobs() []*task.CronJob {
+	m.cronJobsMu.Lock()
+	defer m.cronJobsMu.Unlock()
+	result, err := m.CronJobDb.List()
+	if err != nil {
+		log.Printf("Error getting list of cron jobs: %v\n", err)
+		return nil
+	}
+	var jobs []*task.CronJob
+	for _, c := range result.([]*task.CronJob) {
+		job := *c
+		jobs = append(jobs, &job)
+	}
+	return jobs
+}
+
+func (m *Manager) GetCronJob(name string) (*task.CronJob, error) {
+	m.cronJobsMu.Lock()
+	defer m.cronJobsMu.Unlock()
+	result, err := m.CronJobDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	job := *result.(*task.CronJob)
+	return &job, nil
+}
+
+// DeleteCronJob removes the cron job, the runs it already started are left running.
+func (m *Manager) DeleteCronJob(name string) error {
+	m.cronJobsMu.Lock()
+	defer m.cronJobsMu.Unlock()
+	if _, err := m.CronJobDb.Get(name); err != nil {
+		return err
+	}
+	return m.CronJobDb.Delete(name)
+}
+
+func (m *Manager) ProcessCronJobs() {
+	for {
+		log.Println("Checking for due cron jobs")
+		m.runCronJobs(time.Now())
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) runCronJobs(now time.Time) {
+	m.cronJobsMu.Lock()
+	defer m.cronJobsMu.Unlock()
+
+	result, err := m.CronJobDb.List()
+	if err != nil {
+		log.Printf("Error getting list of cron jobs: %v\n", err)
+		return
+	}
+	for _, c := range result.([]*task.CronJob) {
+		m.runCronJob(c, now)
+		if err := m.CronJobDb.Put(c.Name, c); err != nil {
+			log.Printf("Error storing cron job %s: %v\n", c.Name, err)
+		}
+	}
+}
+
+// runCronJob moves the finished runs of the job to its history and starts a
+// new run if one is due. When several runs were missed, e.g. while the manager
+// was down, only the latest one is started, as long as it is within the job's
+// deadline.
+func (m *Manager) runCronJob(c *task.CronJob, now time.Time) {
+	var active []task.CronRun
+	for _, run := range c.Active {
+		result, err := m.TaskDb.Get(run.TaskID.String())
+		if err != nil {
+			log.Printf("Task %v of cron job %s not found, dropping it\n", run.TaskID, c.Name)
+			continue
+		}
+		t := result.(*task.Task)
+		switch t.State {
+		case task.Completed, task.Failed, task.Cancelled:
+			run.State = t.State
+			run.FinishTime = t.FinishTime
+			c.AddRun(run)
+		default:
+			active = append(active, run)
+		}
+	}
+	c.Active = active
+
+	schedule, err := c.ParseSchedule()
+	if err != nil {
+		log.Printf("Cron job %s has an invalid schedule: %v\n", c.Name, err)
+		return
+	}
+	from := c.LastScheduleTime
+	if from.IsZero() {
+		from = c.CreationTime
+	}
+	var due time.Time
+	for next := schedule.Next(from); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		if !due.IsZero() {
+			c.MissedRuns++
+		}
+		due = next
+	}
+	if due.IsZero() {
+		return
+	}
+	c.LastScheduleTime = due
+
+	deadline := time.Duration(c.StartingDeadlineSeconds) * time.Second
+	if deadline > 0 && now.Sub(due) > deadline {
+		log.Printf("Cron job %s missed its run at %v by more than %v, skipping it\n", c.Name, due, deadline)
+		c.MissedRuns++
+		return
+	}
+
+	if len(c.Active) > 0 {
+		switch c.ConcurrencyPolicy {
+		case task.ConcurrencyForbid:
+			log.Printf("Cron job %s is still running, skipping its run at %v\n", c.Name, due)
+			c.MissedRuns++
+			return
+		case task.ConcurrencyReplace:
+			for _, run := range c.Active {
+				result, err := m.TaskDb.Get(run.TaskID.String())
+				if err != nil {
+					continue
+				}
+				log.Printf("Cron job %s is still running, replacing task %v\n", c.Name, run.TaskID)
+				if err := m.StopTask(result.(*task.Task)); err != nil {
+					log.Printf("Error stopping task %v of cron job %s: %v\n", run.TaskID, c.Name, err)
+				}
+			}
+		}
+	}
+
+	t := c.Task
+	t.ID = uuid.New()
+	t.State = task.Pending
+	t.Transitions = nil
+	t.Name = fmt.Sprintf("%s-%d", c.Name, due.Unix())
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: now,
+		Task:      t,
+	})
+	c.Active = append(c.Active, task.CronRun{TaskID: t.ID, ScheduledTime: due})
+	log.Printf("Cron job %s started task %v for its run at %v\n", c.Name, t.ID, due)
+}
@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"cube/task"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRunCronJobMissedRuns(t *testing.T) {
+	created := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		job      task.CronJob
+		running  bool
+		now      time.Time
+		started  bool
+		missed   int
+		schedule time.Time
+	}{
+		{
+			name:    "nothing due",
+			job:     task.CronJob{Schedule: "*/10 * * * *"},
+			now:     created.Add(5 * time.Minute),
+			started: false,
+		},
+		{
+			name:     "due",
+			job:      task.CronJob{Schedule: "*/10 * * * *"},
+			now:      created.Add(10 * time.Minute),
+			started:  true,
+			schedule: created.Add(10 * time.Minute),
+		},
+		{
+			name:     "only the latest missed run is started",
+			job:      task.CronJob{Schedule: "*/10 * * * *"},
+			now:      created.Add(35 * time.Minute),
+			started:  true,
+			missed:   2,
+			schedule: created.Add(30 * time.Minute),
+		},
+		{
+			name:     "within the starting deadline",
+			job:      task.CronJob{Schedule: "*/10 * * * *", StartingDeadlineSeconds: 600},
+			now:      created.Add(35 * time.Minute),
+			started:  true,
+			missed:   2,
+			schedule: created.Add(30 * time.Minute),
+		},
+		{
+			name:     "past the starting deadline",
+			job:      task.CronJob{Schedule: "*/10 * * * *", StartingDeadlineSeconds: 60},
+			now:      created.Add(35 * time.Minute),
+			started:  false,
+			missed:   3,
+			schedule: created.Add(30 * time.Minute),
+		},
+		{
+			name:     "forbid while the previous run is active",
+			job:      task.CronJob{Schedule: "*/10 * * * *", ConcurrencyPolicy: task.ConcurrencyForbid},
+			running:  true,
+			now:      created.Add(10 * time.Minute),
+			started:  false,
+			missed:   1,
+			schedule: created.Add(10 * time.Minute),
+		},
+		{
+			name:     "allow while the previous run is active",
+			job:      task.CronJob{Schedule: "*/10 * * * *"},
+			running:  true,
+			now:      created.Add(10 * time.Minute),
+			started:  true,
+			schedule: created.Add(10 * time.Minute),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New([]string{"w1:1"}, "roundrobin", "memory")
+			c := tt.job
+			c.Name = "backup"
+			c.CreationTime = created
+			c.Task = task.Task{Name: "backup", Image: "alpine", Kind: task.KindBatch}
+			active := 0
+			if tt.running {
+				prev := &task.Task{ID: uuid.New(), State: task.Running}
+				m.TaskDb.Put(prev.ID.String(), prev)
+				c.Active = []task.CronRun{{TaskID: prev.ID, ScheduledTime: created}}
+				active = 1
+			}
+
+			m.runCronJob(&c, tt.now)
+
+			if tt.started {
+				active++
+			}
+			if len(c.Active) != active {
+				t.Errorf("%d active runs, want %d", len(c.Active), active)
+			}
+			if c.MissedRuns != tt.missed {
+				t.Errorf("MissedRuns = %d, want %d", c.MissedRuns, tt.missed)
+			}
+			if !c.LastScheduleTime.Equal(tt.schedule) {
+				t.Errorf("LastScheduleTime = %v, want %v", c.LastScheduleTime, tt.schedule)
+			}
+			if tt.started && !c.Active[len(c.Active)-1].ScheduledTime.Equal(tt.schedule) {
+				t.Errorf("run scheduled at %v, want %v", c.Active[len(c.Active)-1].ScheduledTime, tt.schedule)
+			}
+		})
+	}
+}
+
+func TestRunCronJobRecordsFinishedRuns(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	done := &task.Task{ID: uuid.New(), State: task.Completed, FinishTime: time.Now()}
+	m.TaskDb.Put(done.ID.String(), done)
+	c := task.CronJob{
+		Name:         "backup",
+		Schedule:     "@daily",
+		CreationTime: time.Now(),
+		Active:       []task.CronRun{{TaskID: done.ID}},
+	}
+
+	m.runCronJob(&c, time.Now())
+
+	if len(c.Active) != 0 {
+		t.Errorf("%d active runs, want the finished one moved to the history", len(c.Active))
+	}
+	if len(c.History) != 1 || c.History[0].State != task.Completed {
+		t.Errorf("history = %+v, want the completed run", c.History)
+	}
+}
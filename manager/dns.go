@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"cube/task"
+	"golang.org/x/net/dns/dnsmessage"
+	"log"
+	"net"
+	"strings"
+)
+
+// dnsDomain is the domain the manager answers for: <name>.cube is a task, by
+// name or id, or a service.
+const dnsDomain = "cube."
+
+// dnsTTL is short since tasks come and go.
+const dnsTTL = 5
+
+// maxUDPSize is the size of a DNS message over UDP without EDNS.
+const maxUDPSize = 512
+
+/*
+  - ServeDNS answers DNS queries over UDP on address: A queries for <name>.cube with the IP
+    addresses of the workers running ready tasks named name, or of the service name, and SRV
+    queries with the host port of each published port of those tasks and the target <task id>.cube.
+
+  - Only running tasks that are ready are returned, so a task is found once its readiness probe
+    passes and is dropped when it fails. Names outside of the cube domain are refused.
+
+  - Queries are answered concurrently from the snapshot of the ready tasks the update loop
+    refreshes, see refreshEndpoints, so an answer lags the task state by up to one pass.
+*/
+func (m *Manager) ServeDNS(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Printf("Serving DNS for %s on %s\n", dnsDomain, address)
+
+	for {
+		buf := make([]byte, maxUDPSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		go func() {
+			resp, err := m.dnsAnswer(buf[:n])
+			if err != nil {
+				log.Printf("Error answering DNS query from %v: %v\n", addr, err)
+				return
+			}
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				log.Printf("Error sending DNS answer to %v: %v\n", addr, err)
+			}
+		}()
+	}
+}
+
+// dnsAnswer builds the response to a DNS query. A query whose question cannot
+// be parsed is answered with a format error; one without even a header is not
+// answered.
+func (m *Manager) dnsAnswer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		header := dnsmessage.Header{ID: h.ID, Response: true, OpCode: h.OpCode, RCode: dnsmessage.RCodeFormatError}
+		msg := dnsmessage.Message{Header: header}
+		return msg.Pack()
+	}
+
+	header := dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RCode: dnsmessage.RCodeSuccess}
+	name := strings.ToLower(q.Name.String())
+	if h.OpCode != 0 || q.Class != dnsmessage.ClassINET {
+		header.RCode = dnsmessage.RCodeNotImplemented
+		return buildDNS(header, q, nil, nil)
+	}
+	if !strings.HasSuffix(name, "."+dnsDomain) {
+		header.Authoritative = false
+		header.RCode = dnsmessage.RCodeRefused
+		return buildDNS(header, q, nil, nil)
+	}
+
+	label := strings.TrimSuffix(name, "."+dnsDomain)
+	endpoints := m.readyEndpoints(func(t *task.Task) bool {
+		// DNS names are case-insensitive, task and service names are not lowercased
+		return strings.EqualFold(t.Name, label) || t.ID.String() == label || strings.EqualFold(t.Labels[task.LabelService], label)
+	})
+	if len(endpoints) == 0 {
+		header.RCode = dnsmessage.RCodeNameError
+		return buildDNS(header, q, nil, nil)
+	}
+
+	var answers, additionals []dnsmessage.Resource
+	switch q.Type {
+	case dnsmessage.TypeA:
+		seen := make(map[string]bool)
+		for _, e := range endpoints {
+			if seen[e.IP.String()] {
+				continue
+			}
+			seen[e.IP.String()] = true
+			answers = append(answers, aRecord(q.Name, e.IP))
+		}
+	case dnsmessage.TypeSRV:
+		for _, e := range endpoints {
+			target, err := dnsmessage.NewName(e.Task.ID.String() + "." + dnsDomain)
+			if err != nil {
+				return nil, err
+			}
+			for _, port := range e.Ports {
+				answers = append(answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: dnsTTL},
+					Body:   &dnsmessage.SRVResource{Priority: 0, Weight: 10, Port: uint16(port), Target: target},
+				})
+			}
+			if len(e.Ports) > 0 {
+				additionals = append(additionals, aRecord(target, e.IP))
+			}
+		}
+	}
+	return buildDNS(header, q, answers, additionals)
+}
+
+func aRecord(name dnsmessage.Name, ip net.IP) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], ip.To4())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: dnsTTL},
+		Body:   &dnsmessage.AResource{A: a},
+	}
+}
+
+// buildDNS packs the response, leaving out the records that do not fit in a UDP
+// message; the response is then marked as truncated.
+func buildDNS(h dnsmessage.Header, q dnsmessage.Question, answers []dnsmessage.Resource, additionals []dnsmessage.Resource) ([]byte, error) {
+	for {
+		msg := dnsmessage.Message{Header: h, Questions: []dnsmessage.Question{q}, Answers: answers, Additionals: additionals}
+		b, err := msg.Pack()
+		if err != nil || len(b) <= maxUDPSize {
+			return b, err
+		}
+		h.Truncated = true
+		switch {
+		case len(additionals) > 0:
+			additionals = nil
+		case len(answers) > 0:
+			answers = answers[:len(answers)-1]
+		default:
+			return b, nil
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"cube/node"
+	"cube/task"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func dnsQuery(t *testing.T, name string, typ dnsmessage.Type) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func dnsResponse(t *testing.T, m *Manager, query []byte) dnsmessage.Message {
+	t.Helper()
+	b, err := m.dnsAnswer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestDNSAnswer(t *testing.T) {
+	m := New([]string{"127.0.0.1:5556"}, "roundrobin", "memory")
+	tk := &task.Task{
+		ID:        uuid.New(),
+		Name:      "web",
+		State:     task.Running,
+		Ready:     true,
+		HostPorts: nat.PortMap{"80/tcp": {{HostPort: "30080"}}},
+	}
+	m.TaskDb.Put(tk.ID.String(), tk)
+	m.assignTask(tk.ID, "127.0.0.1:5556")
+	mixedCase := &task.Task{
+		ID:        uuid.New(),
+		Name:      "Api",
+		Labels:    map[string]string{task.LabelService: "Billing"},
+		State:     task.Running,
+		Ready:     true,
+		HostPorts: nat.PortMap{"80/tcp": {{HostPort: "30081"}}},
+	}
+	m.TaskDb.Put(mixedCase.ID.String(), mixedCase)
+	m.assignTask(mixedCase.ID, "127.0.0.1:5556")
+	m.refreshEndpoints()
+
+	tests := []struct {
+		name  string
+		query []byte
+		rcode dnsmessage.RCode
+		check func(t *testing.T, msg dnsmessage.Message)
+	}{
+		{
+			name:  "A",
+			query: dnsQuery(t, "web.cube.", dnsmessage.TypeA),
+			rcode: dnsmessage.RCodeSuccess,
+			check: func(t *testing.T, msg dnsmessage.Message) {
+				if len(msg.Answers) != 1 || msg.Answers[0].Body.(*dnsmessage.AResource).A != [4]byte{127, 0, 0, 1} {
+					t.Errorf("answers = %v, want 127.0.0.1", msg.Answers)
+				}
+			},
+		},
+		{
+			name:  "SRV",
+			query: dnsQuery(t, "web.cube.", dnsmessage.TypeSRV),
+			rcode: dnsmessage.RCodeSuccess,
+			check: func(t *testing.T, msg dnsmessage.Message) {
+				if len(msg.Answers) != 1 || msg.Answers[0].Body.(*dnsmessage.SRVResource).Port != 30080 {
+					t.Errorf("answers = %v, want port 30080", msg.Answers)
+				}
+			},
+		},
+		{name: "task name in another case", query: dnsQuery(t, "API.cube.", dnsmessage.TypeA), rcode: dnsmessage.RCodeSuccess},
+		{name: "service name in another case", query: dnsQuery(t, "billing.cube.", dnsmessage.TypeA), rcode: dnsmessage.RCodeSuccess},
+		{name: "unknown name", query: dnsQuery(t, "db.cube.", dnsmessage.TypeA), rcode: dnsmessage.RCodeNameError},
+		{name: "other domain", query: dnsQuery(t, "example.com.", dnsmessage.TypeA), rcode: dnsmessage.RCodeRefused},
+		// a header announcing a question that is not there
+		{name: "malformed", query: []byte{0, 42, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3}, rcode: dnsmessage.RCodeFormatError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := dnsResponse(t, m, tt.query)
+			if msg.ID != 42 || msg.RCode != tt.rcode {
+				t.Errorf("got id %d rcode %v, want id 42 rcode %v", msg.ID, msg.RCode, tt.rcode)
+			}
+			if tt.check != nil {
+				tt.check(t, msg)
+			}
+		})
+	}
+}
+
+func TestWorkerAdvertisedAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(node.Info{Name: "worker-1", Address: "10.0.0.7"})
+	}))
+	defer srv.Close()
+	worker := strings.TrimPrefix(srv.URL, "http://")
+
+	m := New([]string{worker}, "roundrobin", "memory")
+	if ip := m.workerIP(worker); !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("workerIP before the worker advertises = %v, want 127.0.0.1", ip)
+	}
+	m.updateWorkerAddress(m.WorkerNodes[0])
+	if ip := m.workerIP(worker); !ip.Equal(net.IPv4(10, 0, 0, 7)) {
+		t.Errorf("workerIP = %v, want the advertised 10.0.0.7", ip)
+	}
+}
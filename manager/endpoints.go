@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"cube/node"
+	"cube/task"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// endpoint is where a ready task is reached: the IP address of its worker and
+// the host ports its published container ports are bound to, in the order of
+// the container ports.
+type endpoint struct {
+	Task  *task.Task
+	IP    net.IP
+	Ports []int
+}
+
+// readyEndpoints returns the endpoints of the running and ready tasks matching
+// the filter, from the snapshot taken by refreshEndpoints.
+func (m *Manager) readyEndpoints(match func(t *task.Task) bool) []endpoint {
+	m.endpointsMu.RLock()
+	defer m.endpointsMu.RUnlock()
+	var endpoints []endpoint
+	for _, e := range m.endpoints {
+		if match(e.Task) {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// refreshEndpoints takes the snapshot of the endpoints of the running and ready
+// tasks the DNS server and the proxy answer from, so they neither list the task
+// store nor resolve workers on every query. The update loop calls it after each
+// pass over the workers.
+func (m *Manager) refreshEndpoints() {
+	result, err := m.TaskDb.List()
+	if err != nil {
+		log.Printf("Error getting list of tasks: %v\n", err)
+		return
+	}
+	var endpoints []endpoint
+	for _, t := range result.([]*task.Task) {
+		if t.State != task.Running || !t.Ready {
+			continue
+		}
+		w, ok := m.workerOf(t.ID)
+		if !ok {
+			continue
+		}
+		ip := m.workerIP(w)
+		if ip == nil {
+			continue
+		}
+		tc := *t
+		endpoints = append(endpoints, endpoint{Task: &tc, IP: ip, Ports: hostPorts(&tc)})
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Task.ID.String() < endpoints[j].Task.ID.String()
+	})
+
+	m.endpointsMu.Lock()
+	defer m.endpointsMu.Unlock()
+	m.endpoints = endpoints
+}
+
+// workerIP returns the IPv4 address tasks on worker w are reached at: the one
+// the worker advertises, or else the one the host of its API resolved to. A
+// worker that could not be resolved when it was registered is tried again.
+func (m *Manager) workerIP(w string) net.IP {
+	m.endpointsMu.RLock()
+	ip := m.workerIPs[w]
+	m.endpointsMu.RUnlock()
+	if ip != nil {
+		return ip
+	}
+	if ip = resolveWorker(w); ip != nil {
+		m.setWorkerIP(w, ip)
+	}
+	return ip
+}
+
+func (m *Manager) setWorkerIP(w string, ip net.IP) {
+	m.endpointsMu.Lock()
+	defer m.endpointsMu.Unlock()
+	m.workerIPs[w] = ip
+}
+
+// updateWorkerAddress records the address the worker of node n advertises, if any.
+func (m *Manager) updateWorkerAddress(n *node.Node) {
+	info, err := n.GetInfo()
+	if err != nil || info.Address == "" {
+		return
+	}
+	ip := net.ParseIP(info.Address).To4()
+	if ip == nil {
+		log.Printf("Worker %s advertises an invalid IPv4 address %q\n", n.Name, info.Address)
+		return
+	}
+	m.setWorkerIP(n.Name, ip)
+}
+
+// resolveWorker returns the IPv4 address of a worker, whose name is the
+// host:port of its API.
+func resolveWorker(w string) net.IP {
+	host, _, err := net.SplitHostPort(w)
+	if err != nil {
+		host = w
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		log.Printf("Unable to resolve worker %s: %v\n", w, err)
+		return nil
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			if ip4.IsLoopback() {
+				log.Printf("Worker %s resolves to %v, other hosts cannot reach its tasks unless it advertises an address\n", w, ip4)
+			}
+			return ip4
+		}
+	}
+	return nil
+}
+
+// hostPorts returns the host ports of the task's published ports, ordered by
+// container port. A task in host network mode is reached on its container ports.
+func hostPorts(t *task.Task) []int {
+	var ports []int
+	if t.NetworkMode.IsHost() {
+		for p := range t.ExposedPort {
+			ports = append(ports, p.Int())
+		}
+		sort.Ints(ports)
+		return ports
+	}
+
+	var published []int
+	byContainerPort := make(map[int]int)
+	for p, bindings := range t.HostPorts {
+		if _, ok := byContainerPort[p.Int()]; ok {
+			continue
+		}
+		for _, b := range bindings {
+			if port, err := strconv.Atoi(b.HostPort); err == nil && port != 0 {
+				published = append(published, p.Int())
+				byContainerPort[p.Int()] = port
+				break
+			}
+		}
+	}
+	sort.Ints(published)
+	for _, p := range published {
+		ports = append(ports, byContainerPort[p])
+	}
+	return ports
+}
@@ -2,13 +2,13 @@ package manager
 
 import (
 	"cube/task"
+	"cube/utils"
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"log"
 	"net/http"
-	"time"
 )
 
 func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +27,34 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err := task.ValidatePullPolicies(te.Task)
+	if err == nil && te.Task.DriverName() == "exec" {
+		err = task.ValidateExecTask(te.Task)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Invalid task %v: %v", te.Task.ID, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		e := ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	if err := a.Manager.CheckResources(te.Task); err != nil {
+		msg := fmt.Sprintf("Task %v cannot be enforced on any worker: %v", te.Task.ID, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		e := ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
 	a.Manager.AddTask(te)
 	log.Printf("Added task %v\n", te.Task.ID)
 	w.WriteHeader(http.StatusCreated)
@@ -62,20 +90,641 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	taskCopy := taskToStop.(*task.Task)
 	//taskCopy.State = task.Completed
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		Timestamp: time.Now(),
-		Task:      *taskCopy,
+	if err := a.Manager.StopTask(taskCopy); err != nil {
+		log.Printf("Error stopping task %v: %v\n", taskCopy.ID, err)
+		w.WriteHeader(http.StatusConflict)
+		return
 	}
-	a.Manager.AddTask(te)
-
-	log.Printf("Added task event %v to stop task %v\n", te.ID, taskCopy.ID)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("Error parsing task ID: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, err := a.Manager.TaskDb.Get(tID.String()); err != nil {
+		log.Printf("Task not found %v\n", tID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp, err := a.Manager.GetTaskLogs(tID, r.URL.RawQuery)
+	if err != nil {
+		msg := fmt.Sprintf("Error getting logs for task %v: %v", tID, err)
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadGateway,
+			Message:        msg,
+		})
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	if err := utils.StreamResponse(w, resp.Body); err != nil {
+		log.Printf("Error streaming logs for task %v: %v\n", tID, err)
+	}
+}
+
+// ExecTaskHandler relays an exec session between the client and the worker that
+// runs the task. Both connections are upgraded to raw streams.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("Error parsing task ID: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, err := a.Manager.TaskDb.Get(tID.String()); err != nil {
+		log.Printf("Task not found %v\n", tID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	upstream, err := a.Manager.ExecTask(tID, r.URL.RawQuery)
+	if err != nil {
+		msg := fmt.Sprintf("Error executing command in task %v: %v", tID, err)
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadGateway,
+			Message:        msg,
+		})
+		return
+	}
+	defer upstream.Close()
+
+	conn, err := utils.Upgrade(w)
+	if err != nil {
+		log.Printf("Error upgrading exec connection for task %v: %v\n", tID, err)
+		return
+	}
+	defer conn.Close()
+
+	utils.Splice(conn, upstream)
+}
+
 func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(a.Manager.WorkerNodes)
 }
+
+func (a *Api) AddRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	auth := task.RegistryAuth{}
+	if err := d.Decode(&auth); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+	if auth.Server == "" {
+		auth.Server = task.DefaultRegistry
+	}
+
+	if err := a.Manager.AddRegistryAuth(auth); err != nil {
+		log.Printf("Error storing credentials for registry %s: %v\n", auth.Server, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        err.Error(),
+		})
+		return
+	}
+	log.Printf("Added credentials for registry %s\n", auth.Server)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (a *Api) GetRegistriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetRegistries())
+}
+
+func (a *Api) RemoveRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	server := chi.URLParam(r, "server")
+	if !a.Manager.RemoveRegistryAuth(server) {
+		log.Printf("No credentials for registry %s\n", server)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Removed credentials for registry %s\n", server)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) AddWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	wf := Workflow{}
+	if err := d.Decode(&wf); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	if err := a.Manager.AddWorkflow(&wf); err != nil {
+		msg := fmt.Sprintf("Workflow %s is invalid: %v", wf.Name, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	log.Printf("Added workflow %v\n", wf.ID)
+	created, _ := a.Manager.GetWorkflow(wf.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetWorkflowsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetWorkflows())
+}
+
+func (a *Api) GetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	workflowID := chi.URLParam(r, "workflowID")
+	wfID, err := uuid.Parse(workflowID)
+	if err != nil {
+		log.Printf("Error parsing workflow ID: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	wf, ok := a.Manager.GetWorkflow(wfID)
+	if !ok {
+		log.Printf("Workflow not found %v\n", wfID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(wf)
+}
+
+func (a *Api) CancelWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	workflowID := chi.URLParam(r, "workflowID")
+	wfID, err := uuid.Parse(workflowID)
+	if err != nil {
+		log.Printf("Error parsing workflow ID: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.Manager.GetWorkflow(wfID); !ok {
+		log.Printf("Workflow not found %v\n", wfID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := a.Manager.CancelWorkflow(wfID); err != nil {
+		log.Printf("Error cancelling workflow %v: %v\n", wfID, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	log.Printf("Cancelled workflow %v\n", wfID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) AddCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	c := task.CronJob{}
+	if err := d.Decode(&c); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	if err := a.Manager.AddCronJob(&c); err != nil {
+		msg := fmt.Sprintf("Cron job %s cannot be added: %v", c.Name, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	log.Printf("Added cron job %s\n", c.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (a *Api) GetCronJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetCronJobs())
+}
+
+func (a *Api) GetCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	c, err := a.Manager.GetCronJob(name)
+	if err != nil {
+		log.Printf("Cron job not found %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (a *Api) DeleteCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := a.Manager.DeleteCronJob(name); err != nil {
+		log.Printf("Error deleting cron job %s: %v\n", name, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Deleted cron job %s\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) AddServiceHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	s := task.Service{}
+	if err := d.Decode(&s); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	if err := a.Manager.AddService(&s); err != nil {
+		msg := fmt.Sprintf("Service %s cannot be added: %v", s.Name, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	log.Printf("Added service %s\n", s.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetServices())
+}
+
+func (a *Api) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	s, err := a.Manager.GetService(name)
+	if err != nil {
+		log.Printf("Service not found %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+type ScaleRequest struct {
+	Replicas int
+}
+
+func (a *Api) ScaleServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, err := a.Manager.GetService(name); err != nil {
+		log.Printf("Service not found %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req := ScaleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	s, err := a.Manager.ScaleService(name, req.Replicas)
+	if err != nil {
+		msg := fmt.Sprintf("Service %s cannot be scaled: %v", name, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+// UpdateServiceRequest carries the new task template of a service and, when
+// set, the settings of its rolling update.
+type UpdateServiceRequest struct {
+	Template     task.Task
+	UpdateConfig *task.UpdateConfig
+}
+
+func (a *Api) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, err := a.Manager.GetService(name); err != nil {
+		log.Printf("Service not found %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := UpdateServiceRequest{}
+	if err := d.Decode(&req); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	s, err := a.Manager.UpdateService(name, req.Template, req.UpdateConfig)
+	if err != nil {
+		msg := fmt.Sprintf("Service %s cannot be updated: %v", name, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) RollbackServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, err := a.Manager.GetService(name); err != nil {
+		log.Printf("Service not found %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s, err := a.Manager.RollbackService(name)
+	if err != nil {
+		msg := fmt.Sprintf("Service %s cannot be rolled back: %v", name, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusConflict,
+			Message:        msg,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := a.Manager.DeleteService(name); err != nil {
+		log.Printf("Error deleting service %s: %v\n", name, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Deleted service %s\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type secretRequest struct {
+	Name  string
+	Value string
+}
+
+func (a *Api) AddSecretHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := secretRequest{}
+	if err := d.Decode(&req); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	s, err := a.Manager.AddSecret(req.Name, req.Value)
+	if err != nil {
+		log.Printf("Error storing secret %s: %v\n", req.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) GetSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetSecrets())
+}
+
+func (a *Api) DeleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := a.Manager.DeleteSecret(name); err != nil {
+		log.Printf("No secret named %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Deleted secret %s\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type configRequest struct {
+	Name string
+	Data string
+}
+
+func (a *Api) AddConfigHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := configRequest{}
+	if err := d.Decode(&req); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	c, err := a.Manager.AddConfig(req.Name, req.Data)
+	if err != nil {
+		log.Printf("Error storing config %s: %v\n", req.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (a *Api) GetConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetConfigs())
+}
+
+func (a *Api) GetConfigHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	c, err := a.Manager.GetConfig(name)
+	if err != nil {
+		log.Printf("No config named %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (a *Api) DeleteConfigHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := a.Manager.DeleteConfig(name); err != nil {
+		log.Printf("No config named %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Deleted config %s\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) AddRouteHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	route := task.Route{}
+	if err := d.Decode(&route); err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		})
+		return
+	}
+
+	if err := a.Manager.AddRoute(&route); err != nil {
+		log.Printf("Error adding route %s: %v\n", route.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(route)
+}
+
+func (a *Api) GetRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetRoutes())
+}
+
+func (a *Api) DeleteRouteHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := a.Manager.DeleteRoute(name); err != nil {
+		log.Printf("No route named %s\n", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Deleted route %s\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
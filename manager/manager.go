@@ -6,21 +6,23 @@ import (
 	"cube/scheduler"
 	"cube/store"
 	"cube/task"
+	"cube/utils"
 	"cube/worker"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/docker/go-connections/nat"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 	"log"
+	"net"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 )
 
 type Manager struct {
 	Pending       queue.Queue
+	pendingMu     sync.Mutex
 	TaskDb        store.Store
 	EventDb       store.Store
 	Workers       []string
@@ -29,6 +31,48 @@ type Manager struct {
 	LastWorker    int
 	WorkerNodes   []*node.Node
 	Scheduler     scheduler.Scheduler
+	// RegistryDb holds private registry credentials by registry host, encrypted
+	// with the secrets key. They are sent to a worker with every task whose
+	// images come from that registry.
+	RegistryDb   store.Store
+	registriesMu sync.Mutex
+	// StateMachine applies every state change the manager makes to its tasks and
+	// records each of them as an event in EventDb.
+	StateMachine *task.StateMachine
+	// Workflows are kept in memory; workflowTasks maps the task of every step
+	// attempt to its workflow.
+	Workflows     map[uuid.UUID]*Workflow
+	workflowTasks map[uuid.UUID]uuid.UUID
+	workflowsMu   sync.Mutex
+	// CronJobDb holds the cron jobs by name, persisted like the tasks with the
+	// "persistent" db type.
+	CronJobDb  store.Store
+	cronJobsMu sync.Mutex
+	// ServiceDb holds the services by name; their tasks are kept in TaskDb.
+	ServiceDb  store.Store
+	servicesMu sync.Mutex
+	// SecretDb holds the secrets by name, their values encrypted with the
+	// secrets key (see SetSecretsKey).
+	SecretDb     store.Store
+	secretCipher *task.SecretCipher
+	secretsMu    sync.Mutex
+	// ConfigDb holds the configs by name with their latest versions.
+	ConfigDb  store.Store
+	configsMu sync.Mutex
+	// RouteDb holds the routes of the manager's proxy by name.
+	RouteDb  store.Store
+	routesMu sync.Mutex
+	// rescheduledFrom maps a task restarted on another worker to the worker it
+	// ran on, which SelectWorker avoids.
+	rescheduledFrom map[uuid.UUID]string
+	placementMu     sync.RWMutex
+	// endpoints is the snapshot of the ready tasks the DNS server and the proxy
+	// answer from, routes the one of the proxy's routes; workerIPs holds the
+	// address the tasks of each worker are reached at.
+	endpoints   []endpoint
+	routes      []*task.Route
+	workerIPs   map[string]net.IP
+	endpointsMu sync.RWMutex
 }
 
 func New(workers []string, schedulerType string, dbType string) *Manager {
@@ -37,8 +81,12 @@ func New(workers []string, schedulerType string, dbType string) *Manager {
 	taskWorkerMap := make(map[uuid.UUID]string)
 
 	var nodes []*node.Node
+	workerIPs := make(map[string]net.IP)
 	for w := range workers {
 		workerTaskMap[workers[w]] = []uuid.UUID{}
+		if ip := resolveWorker(workers[w]); ip != nil {
+			workerIPs[workers[w]] = ip
+		}
 
 		nodeAPI := fmt.Sprintf("http://%v", workers[w])
 		n := node.NewNode(workers[w], nodeAPI, "worker")
@@ -62,34 +110,87 @@ func New(workers []string, schedulerType string, dbType string) *Manager {
 		TaskWorkerMap: taskWorkerMap,
 		WorkerNodes:   nodes,
 		Scheduler:     s,
+		workerIPs:     workerIPs,
+		Workflows:     make(map[uuid.UUID]*Workflow),
+		workflowTasks: make(map[uuid.UUID]uuid.UUID),
+
+		rescheduledFrom: make(map[uuid.UUID]string),
 	}
 
 	var ts store.Store
 	var es store.Store
+	var cs store.Store
+	var ss store.Store
+	var sec store.Store
+	var cfg store.Store
+	var rs store.Store
+	var reg store.Store
 	var err error
 
 	switch dbType {
 	case "memory":
-		ts = store.NewInMemoryTaskStore()
-		es = store.NewInMemoryTaskEventStore()
+		ts = store.NewInMemoryStore[task.Task]("task")
+		es = store.NewInMemoryStore[task.TaskEvent]("task event")
+		cs = store.NewInMemoryStore[task.CronJob]("cron job")
+		ss = store.NewInMemoryStore[task.Service]("service")
+		sec = store.NewInMemoryStore[task.Secret]("secret")
+		cfg = store.NewInMemoryStore[task.AppConfig]("config")
+		rs = store.NewInMemoryStore[task.Route]("route")
+		reg = store.NewInMemoryStore[task.RegistryCredentials]("registry")
 	case "persistent":
-		ts, err = store.NewTaskStore("tasks.db", 0600, "tasks")
+		ts, err = store.NewJSONStore[task.Task]("tasks.db", 0600, "tasks", "task")
 		if err != nil {
 			log.Fatalf("unable to create task store: %v", err)
 		}
-		es, err = store.NewEventStore("events.db", 0600, "events")
+		es, err = store.NewJSONStore[task.TaskEvent]("events.db", 0600, "events", "event")
 		if err != nil {
 			log.Fatalf("unable to create task event store: %v", err)
 		}
+		cs, err = store.NewJSONStore[task.CronJob]("cronjobs.db", 0600, "cronjobs", "cron job")
+		if err != nil {
+			log.Fatalf("unable to create cron job store: %v", err)
+		}
+		ss, err = store.NewJSONStore[task.Service]("services.db", 0600, "services", "service")
+		if err != nil {
+			log.Fatalf("unable to create service store: %v", err)
+		}
+		sec, err = store.NewJSONStore[task.Secret]("secrets.db", 0600, "secrets", "secret")
+		if err != nil {
+			log.Fatalf("unable to create secret store: %v", err)
+		}
+		cfg, err = store.NewJSONStore[task.AppConfig]("configs.db", 0600, "configs", "config")
+		if err != nil {
+			log.Fatalf("unable to create config store: %v", err)
+		}
+		rs, err = store.NewJSONStore[task.Route]("routes.db", 0600, "routes", "route")
+		if err != nil {
+			log.Fatalf("unable to create route store: %v", err)
+		}
+		reg, err = store.NewJSONStore[task.RegistryCredentials]("registries.db", 0600, "registries", "registry")
+		if err != nil {
+			log.Fatalf("unable to create registry store: %v", err)
+		}
 	}
 
 	m.TaskDb = ts
 	m.EventDb = es
+	m.CronJobDb = cs
+	m.ServiceDb = ss
+	m.SecretDb = sec
+	m.ConfigDb = cfg
+	m.RouteDb = rs
+	m.RegistryDb = reg
+	m.StateMachine = task.NewStateMachine(es)
+	m.StateMachine.AddHook(m.onTaskTransition)
+	m.StateMachine.AddHook(m.onPortsTransition)
 	return &m
 }
 
 func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
 	candidates := m.Scheduler.SelectCandidateNodes(t, m.WorkerNodes)
+	if previous, ok := m.movingFrom(t.ID); ok {
+		candidates = withoutNode(candidates, previous)
+	}
 	if candidates == nil {
 		return nil, errors.New(fmt.Sprintf("No availabe candidates match resource request for task %v", t.ID))
 
@@ -103,10 +204,37 @@ func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
 	return selectedNode, nil
 }
 
+// withoutNode drops the named node from the candidates, unless it is the only one.
+func withoutNode(candidates []*node.Node, name string) []*node.Node {
+	var others []*node.Node
+	for _, n := range candidates {
+		if n.Name != name {
+			others = append(others, n)
+		}
+	}
+	if len(others) == 0 {
+		return candidates
+	}
+	return others
+}
+
+// CheckResources rejects a task that no worker can run with all the limits it asks for.
+func (m *Manager) CheckResources(t task.Task) error {
+	var err error
+	for _, n := range m.WorkerNodes {
+		if err = n.CheckResources(t); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 func (m *Manager) UpdateTasks() {
 	for {
 		log.Printf("Checking for task updates from workers")
 		m.updateTasks()
+		m.refreshEndpoints()
+		m.refreshRoutes()
 		log.Println("Task updates completed")
 		log.Println("Sleeping for 15 seconds")
 		time.Sleep(15 * time.Second)
@@ -120,6 +248,7 @@ func (m *Manager) updateTasks() {
 		resp, err := http.Get(url)
 		if err != nil {
 			log.Printf("[manager] Error connecting to %v: %v", w, err)
+			m.markTasksLost(w)
 			continue
 		}
 
@@ -148,20 +277,90 @@ func (m *Manager) updateTasks() {
 				log.Printf("[manager] cannot convert result %v to task.Task type\n", result)
 				continue
 			}
+			// a task restarted on another worker is still listed by the one it left
+			owner, ok := m.workerOf(t.ID)
+			if ok && owner != w {
+				continue
+			}
+			if from, moving := m.movingFrom(t.ID); moving && from == w {
+				continue
+			}
+			if !ok {
+				m.adoptTask(taskPersisted, w)
+			}
+			if taskPersisted.State == task.Cancelled && !portsReleased(t.State) {
+				log.Printf("[manager] Task %v was cancelled but worker %v runs it, stopping it\n", t.ID, w)
+				m.stopTask(w, t.ID.String())
+				continue
+			}
 
 			if taskPersisted.State != t.State {
-				taskPersisted.State = t.State
+				// keep the reason the worker recorded for the state it reports
+				reason, message := task.ReasonWorkerReported, ""
+				if tr := t.LastTransition(); tr != nil && tr.To == t.State {
+					reason, message = tr.Reason, tr.Message
+				}
+				if err := m.StateMachine.Transition(taskPersisted, t.State, reason, message); err != nil {
+					log.Printf("[manager] ignoring state reported by worker %v: %v\n", w, err)
+				}
 			}
 			taskPersisted.StartTime = t.StartTime
 			taskPersisted.FinishTime = t.FinishTime
+			taskPersisted.ExitCode = t.ExitCode
+			taskPersisted.OOMKilled = t.OOMKilled
 			taskPersisted.ContainerID = t.ContainerID
+			taskPersisted.Containers = t.Containers
 			taskPersisted.HostPorts = t.HostPorts
+			taskPersisted.Liveness = t.Liveness
+			taskPersisted.Readiness = t.Readiness
+			taskPersisted.UpdateReady()
 
 			m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
 		}
 	}
 }
 
+// adoptTask records that the task runs on worker w, which reported it although
+// the manager had no placement for it, e.g. because the manager restarted. The
+// task holds the host ports stored with it again.
+func (m *Manager) adoptTask(t *task.Task, w string) {
+	if t.State == task.Pending || t.State == task.Cancelled {
+		return
+	}
+	log.Printf("[manager] Task %v runs on worker %v\n", t.ID, w)
+	m.assignTask(t.ID, w)
+	if !portsReleased(t.State) {
+		m.holdPorts(t, w)
+	}
+}
+
+// RestoreTasks asks the workers for the tasks they run, so a restarted manager
+// knows where its stored tasks run, and which host ports they hold, before it
+// schedules new ones.
+func (m *Manager) RestoreTasks() {
+	m.updateTasks()
+	m.refreshEndpoints()
+	m.refreshRoutes()
+}
+
+// markTasksLost moves the tasks of a worker that cannot be reached to Lost. They
+// go back to the state the worker reports once it is reachable again.
+func (m *Manager) markTasksLost(w string) {
+	for _, id := range m.workerTasks(w) {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		if t.State == task.Lost || !task.ValidateStateTransition(t.State, task.Lost) {
+			continue
+		}
+		log.Printf("[manager] Worker %v is unreachable, marking task %v as lost\n", w, t.ID)
+		m.StateMachine.Transition(t, task.Lost, task.ReasonWorkerUnreachable, fmt.Sprintf("worker %s cannot be reached", w))
+		m.TaskDb.Put(t.ID.String(), t)
+	}
+}
+
 func (m *Manager) ProcessTasks() {
 	for {
 		log.Printf("Processing any tasks in the queue")
@@ -195,15 +394,13 @@ func (m *Manager) stopTask(worker string, taskID string) {
 }
 
 func (m *Manager) SendWork() {
-	if m.Pending.Len() > 0 {
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
+	if te, ok := m.dequeue(); ok {
 		if err := m.EventDb.Put(te.ID.String(), &te); err != nil {
 			log.Printf("error attempting to store task event %s: %s\n", te.ID.String(), err)
 		}
 		log.Printf("Pulled %v off pending queue\n", te)
 
-		taskWorker, ok := m.TaskWorkerMap[te.Task.ID]
+		taskWorker, ok := m.workerOf(te.Task.ID)
 		if ok {
 			result, err := m.TaskDb.Get(te.Task.ID.String())
 			if err != nil {
@@ -229,18 +426,51 @@ func (m *Manager) SendWork() {
 		}
 
 		t := te.Task
+		t.State = task.Pending
+		if result, err := m.TaskDb.Get(t.ID.String()); err == nil {
+			persistedTask := result.(*task.Task)
+			t.State = persistedTask.State
+			t.Transitions = persistedTask.Transitions
+		}
+		if !task.ValidateStateTransition(t.State, task.Scheduled) {
+			log.Printf("task %s is %v and will not be scheduled\n", t.ID, t.State)
+			return
+		}
+
 		w, err := m.SelectWorker(t)
 		if err != nil {
 			log.Printf("Error selecting worker %s for task: %v\n", t.ID, err)
 			return
 		}
-		m.WorkerTaskMap[w.Name] = append(m.WorkerTaskMap[w.Name], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w.Name
+		m.assignTask(t.ID, w.Name)
 
-		t.State = task.Scheduled
-		m.TaskDb.Put(t.ID.String(), &t)
+		m.StateMachine.Transition(&t, task.Scheduled, task.ReasonScheduled, fmt.Sprintf("scheduled on worker %s", w.Name))
+		ports, err := m.allocatePorts(&t, w.Name)
+		if err != nil {
+			log.Printf("Unable to allocate host ports for task %s: %v\n", t.ID, err)
+			m.StateMachine.Transition(&t, task.Failed, task.ReasonStartError, err.Error())
+			m.TaskDb.Put(t.ID.String(), &t)
+			return
+		}
 
-		data, err := json.Marshal(te)
+		// the worker starts the task from the state the manager has just given it;
+		// secrets and registry credentials only go into the copy sent to it, te
+		// is stored in EventDb and requeued as it is
+		out := te
+		out.Task = t
+		out, err = m.withTaskData(out)
+		t = out.Task
+		if err != nil {
+			log.Printf("Unable to start task %s: %v\n", t.ID, err)
+			m.StateMachine.Transition(&t, task.Failed, task.ReasonInvalidSpec, err.Error())
+			m.TaskDb.Put(t.ID.String(), &t)
+			return
+		}
+		m.TaskDb.Put(t.ID.String(), &t)
+		if len(ports) > 0 {
+			out.Task.PortBindings = ports
+		}
+		data, err := json.Marshal(out)
 		if err != nil {
 			log.Printf("Unable to marshal task object: %v.\n", err)
 		}
@@ -249,25 +479,36 @@ func (m *Manager) SendWork() {
 		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 		if err != nil {
 			log.Printf("Error connecting to %v: %v.\n", w.Name, err)
-			m.Pending.Enqueue(te)
+			m.releasePorts(&t)
+			m.moveTaskOff(t.ID, w.Name)
+			m.enqueue(te)
 			return
 		}
 
+		defer resp.Body.Close()
 		d := json.NewDecoder(resp.Body)
 		if resp.StatusCode != http.StatusCreated {
-			errResponse := worker.ErrResponse{}
-			errResponseDecode := d.Decode(&errResponse)
-			if errResponseDecode != nil {
-				fmt.Printf("Error decoding response: %v\n", errResponseDecode.Error())
+			errResponse := worker.ErrResponse{HttpStatusCode: resp.StatusCode, Message: resp.Status}
+			if err := d.Decode(&errResponse); err != nil {
+				log.Printf("Error decoding response from %v: %v\n", w.Name, err)
+			}
+			log.Printf("Response error (%d): %s", errResponse.HttpStatusCode, errResponse.Message)
+			// the worker rejected the task, any other error may be gone when it
+			// is sent again, e.g. to a worker that has finished restarting
+			if resp.StatusCode == http.StatusBadRequest {
+				m.StateMachine.Transition(&t, task.Failed, task.ReasonRejected, errResponse.Message)
+				m.TaskDb.Put(t.ID.String(), &t)
 				return
 			}
-			log.Printf("Resonse error (%d): %s", errResponse.HttpStatusCode, errResponse.Message)
+			m.releasePorts(&t)
+			m.moveTaskOff(t.ID, w.Name)
+			m.enqueue(te)
 			return
 		}
 
 		t = task.Task{}
 		if err = d.Decode(&t); err != nil {
-			fmt.Printf("Error decoding response: %s.\n", err.Error())
+			log.Printf("Error decoding response from %v: %v\n", w.Name, err)
 			return
 		}
 		log.Printf("put task: %v in the worker %s\n", t, w.Name)
@@ -276,10 +517,78 @@ func (m *Manager) SendWork() {
 	}
 }
 
+// AddTask queues the event. A task the manager has not seen before is stored
+// as Pending, so it is listed, and can be cancelled, before it is scheduled.
 func (m *Manager) AddTask(te task.TaskEvent) {
+	if _, err := m.TaskDb.Get(te.Task.ID.String()); err != nil {
+		t := te.Task
+		t.State = task.Pending
+		m.TaskDb.Put(t.ID.String(), &t)
+	}
+	m.enqueue(te)
+}
+
+// enqueue and dequeue guard the Pending queue, which SendWork takes events from
+// while handlers and the manager's other loops add to it.
+func (m *Manager) enqueue(te task.TaskEvent) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
 	m.Pending.Enqueue(te)
 }
 
+func (m *Manager) dequeue() (task.TaskEvent, bool) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if m.Pending.Len() == 0 {
+		return task.TaskEvent{}, false
+	}
+	return m.Pending.Dequeue().(task.TaskEvent), true
+}
+
+// CancelTask stops a task that has not started yet. SendWork skips a pending
+// one when its event comes off the queue, and a scheduled one its worker starts
+// anyway is stopped once the worker reports it.
+func (m *Manager) CancelTask(t *task.Task) error {
+	if err := m.StateMachine.Transition(t, task.Cancelled, task.ReasonCancelled, "cancelled before being started"); err != nil {
+		return err
+	}
+	t.FinishTime = time.Now()
+	return m.TaskDb.Put(t.ID.String(), t)
+}
+
+// StopTask cancels a task that is still pending or scheduled, otherwise it
+// queues an event asking the task's worker to stop it.
+func (m *Manager) StopTask(t *task.Task) error {
+	if t.State == task.Pending || t.State == task.Scheduled {
+		return m.CancelTask(t)
+	}
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now(),
+		Task:      *t,
+	}
+	m.AddTask(te)
+	log.Printf("Added task event %v to stop task %v\n", te.ID, t.ID)
+	return nil
+}
+
+// withTaskData returns a copy of the event carrying the configs, secrets and
+// registry credentials the task needs, with the versions of the configs
+// recorded in the task.
+func (m *Manager) withTaskData(te task.TaskEvent) (task.TaskEvent, error) {
+	te, err := m.withConfigs(te)
+	if err != nil {
+		return te, err
+	}
+	te, err = m.withSecrets(te)
+	if err != nil {
+		return te, err
+	}
+	return m.withRegistryAuths(te)
+}
+
 func (m *Manager) GetTasks() []*task.Task {
 	taskList, err := m.TaskDb.List()
 	if err != nil {
@@ -289,55 +598,65 @@ func (m *Manager) GetTasks() []*task.Task {
 	return taskList.([]*task.Task)
 }
 
-func (m *Manager) checkTasksHealth(t task.Task) error {
-	log.Printf("Calling health check for task %s: %s\n", t.ID, t.HealthCheck)
+// GetTaskLogs asks the worker that owns the task for its logs. The query is
+// passed through unchanged, so the worker's follow, tail and since options apply.
+func (m *Manager) GetTaskLogs(taskID uuid.UUID, query string) (*http.Response, error) {
+	w, ok := m.workerOf(taskID)
+	if !ok {
+		return nil, fmt.Errorf("task %s is not assigned to any worker", taskID)
+	}
 
-	w := m.TaskWorkerMap[t.ID]
-	hostPort := getHostPort(t.HostPorts)
-	wrkr := strings.Split(w, ":")
-	if hostPort == nil {
-		log.Printf("Have not collected task %s host port yest. Skipping.\n", t.ID)
-		return nil
+	url := fmt.Sprintf("http://%s/tasks/%s/logs", w, taskID)
+	if query != "" {
+		url = fmt.Sprintf("%s?%s", url, query)
 	}
-	url := fmt.Sprintf("http://%s:%s%s", wrkr[0], *hostPort, t.HealthCheck)
-	log.Printf("Calling health check for task %s: %s\n", t.ID, url)
 	resp, err := http.Get(url)
 	if err != nil {
-		msg := fmt.Sprintf("Error connecting to health check %s\n", url)
-		log.Printf(msg)
-		return errors.New(msg)
+		log.Printf("Error connecting to %v: %v\n", w, err)
+		return nil, err
 	}
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		msg := fmt.Sprintf("Error health check for task %s did not return 200\n", t.ID)
-		log.Printf(msg)
-		return errors.New(msg)
+// ExecTask opens an exec session on the worker that owns the task and returns
+// the upgraded connection to it.
+func (m *Manager) ExecTask(taskID uuid.UUID, query string) (net.Conn, error) {
+	w, ok := m.workerOf(taskID)
+	if !ok {
+		return nil, fmt.Errorf("task %s is not assigned to any worker", taskID)
 	}
 
-	log.Printf("Task %s health check response %v\n", t.ID, resp.StatusCode)
-	return nil
-}
-
-func getHostPort(ports nat.PortMap) *string {
-	for k, _ := range ports {
-		return &ports[k][0].HostPort
+	path := fmt.Sprintf("/tasks/%s/exec?%s", taskID, query)
+	conn, err := utils.DialUpgrade(w, path)
+	if err != nil {
+		log.Printf("Error opening exec session on %v: %v\n", w, err)
+		return nil, err
 	}
-	return nil
+	return conn, nil
 }
 
+// doHealthChecks restarts the tasks whose restart policy asks for it once their
+// backoff has passed. Probes run on the workers, which report the outcome with
+// the task; a failing readiness probe only clears the task's Ready condition.
+// Then the next task running an outdated config is rolled.
 func (m *Manager) doHealthChecks() {
+	now := time.Now()
 	for _, t := range m.GetTasks() {
-		if t.State == task.Running && t.RestartCount < 3 {
-			if err := m.checkTasksHealth(*t); err != nil {
-				//if t.RestartCount < 3 {
-				//	m.restartTask(t)
-				//}
-				m.restartTask(t)
-			}
-		} else if t.State == task.Failed && t.RestartCount < 3 {
-			m.restartTask(t)
+		// workflows retry their failed steps and services replace their failed tasks
+		if t.State != task.Running && (m.isWorkflowTask(t.ID) || t.Labels[task.LabelService] != "") {
+			continue
+		}
+		at, ok := t.NextRestart()
+		if !ok || now.Before(at) {
+			continue
+		}
+		if t.State == task.Running {
+			m.restartTask(t, task.ReasonHealthCheckFailed, t.Liveness.Message)
+		} else {
+			m.restartTask(t, task.ReasonRestarted, fmt.Sprintf("restart %d after the task ended", t.RestartCount+1))
 		}
 	}
+	m.rollConfigTasks()
 }
 
 func (m *Manager) DoHealthChecks() {
@@ -345,23 +664,73 @@ func (m *Manager) DoHealthChecks() {
 		log.Println("Performing task health check")
 		m.doHealthChecks()
 		log.Println("Task health check completed")
-		log.Println("Sleeping for 40 seconds")
-		time.Sleep(35 * time.Second)
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
 	}
 }
 
-func (m *Manager) restartTask(t *task.Task) {
-	w := m.TaskWorkerMap[t.ID]
-	t.State = task.Scheduled
+func (m *Manager) restartTask(t *task.Task, reason string, message string) {
+	w, _ := m.workerOf(t.ID)
+	if t.Restart.Reschedule && len(m.Workers) > 1 {
+		m.rescheduleTask(t, w, reason, message)
+		return
+	}
+
+	// a running task has to be replaced by the worker, a failed one is simply started again
+	next := task.Scheduled
+	if t.State == task.Running {
+		next = task.Restarting
+	}
+	if err := m.StateMachine.Transition(t, next, reason, message); err != nil {
+		log.Printf("task %s cannot be restarted: %v\n", t.ID, err)
+		return
+	}
 	t.RestartCount++
-	m.TaskDb.Put(t.ID.String(), t)
+	m.sendRestart(t, w)
+}
+
+// rollTask replaces a running task on its worker, e.g. to pick up a new version
+// of a config, without counting it as a restart.
+func (m *Manager) rollTask(t *task.Task, reason string, message string) {
+	if err := m.StateMachine.Transition(t, task.Restarting, reason, message); err != nil {
+		log.Printf("task %s cannot be restarted: %v\n", t.ID, err)
+		return
+	}
+	w, _ := m.workerOf(t.ID)
+	m.sendRestart(t, w)
+}
 
+// sendRestart asks worker w to start the task again, from the state it was just
+// moved to.
+func (m *Manager) sendRestart(t *task.Task, w string) {
+	t.Liveness = task.ProbeStatus{}
+	t.Readiness = task.ProbeStatus{}
+	ports, err := m.allocatePorts(t, w)
+	if err != nil {
+		log.Printf("Unable to allocate host ports for task %s: %v\n", t.ID, err)
+		m.StateMachine.Transition(t, task.Failed, task.ReasonStartError, err.Error())
+		m.TaskDb.Put(t.ID.String(), t)
+		return
+	}
 	te := task.TaskEvent{
 		ID:        uuid.New(),
 		State:     task.Running,
 		Timestamp: time.Now(),
 		Task:      *t,
 	}
+	te, err = m.withTaskData(te)
+	*t = te.Task
+	if err != nil {
+		log.Printf("Unable to restart task %s: %v\n", t.ID, err)
+		m.StateMachine.Transition(t, task.Failed, task.ReasonInvalidSpec, err.Error())
+		m.TaskDb.Put(t.ID.String(), t)
+		return
+	}
+	m.TaskDb.Put(t.ID.String(), t)
+	if len(ports) > 0 {
+		te.Task.PortBindings = ports
+	}
+
 	data, err := json.Marshal(te)
 	if err != nil {
 		log.Printf("Unable to marshal task object: %v.\n", err)
@@ -372,7 +741,6 @@ func (m *Manager) restartTask(t *task.Task) {
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		log.Printf("Error connecting to %v: %v.\n", w, err)
-		m.Pending.Enqueue(t)
 		return
 	}
 
@@ -396,6 +764,43 @@ func (m *Manager) restartTask(t *task.Task) {
 	log.Printf("Task %v was set to Scheduled state and sent via POST request. \n", t)
 }
 
+// rescheduleTask restarts the task through the scheduler instead of on the
+// worker it ran on, which is asked to stop it if it still runs.
+func (m *Manager) rescheduleTask(t *task.Task, w string, reason string, message string) {
+	// an ended task still leaves its container on the worker, it is removed too
+	hasContainer := t.State == task.Running || t.ContainerID != ""
+	if t.State == task.Running {
+		if err := m.StateMachine.Transition(t, task.Restarting, reason, message); err != nil {
+			log.Printf("task %s cannot be restarted: %v\n", t.ID, err)
+			return
+		}
+	}
+	if err := m.StateMachine.Transition(t, task.Scheduled, reason, fmt.Sprintf("%s, moving off worker %s", message, w)); err != nil {
+		log.Printf("task %s cannot be restarted: %v\n", t.ID, err)
+		return
+	}
+	t.RestartCount++
+	t.ContainerID = ""
+	t.Liveness = task.ProbeStatus{}
+	t.Readiness = task.ProbeStatus{}
+	m.TaskDb.Put(t.ID.String(), t)
+
+	m.releasePorts(t)
+	// updateTasks ignores what w reports about the task from here on
+	m.moveTaskOff(t.ID, w)
+	if hasContainer {
+		m.stopTask(w, t.ID.String())
+	}
+
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task:      *t,
+	})
+	log.Printf("Task %v is rescheduled away from worker %s\n", t.ID, w)
+}
+
 // UpdateNodeStats TODO: is this method really necessary since the scheduler is calling node.GetStats() itself?
 func (m *Manager) UpdateNodeStats() {
 	for {
@@ -405,6 +810,10 @@ func (m *Manager) UpdateNodeStats() {
 			if err != nil {
 				log.Printf("error updating node stats: %v", err)
 			}
+			if _, err := n.GetCapabilities(); err != nil {
+				log.Printf("error updating node capabilities: %v", err)
+			}
+			m.updateWorkerAddress(n)
 		}
 		time.Sleep(15 * time.Second)
 	}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestSendWorkHandlesWorkerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(w http.ResponseWriter)
+		requeue bool
+	}{
+		{
+			name: "rejected",
+			respond: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(ErrResponse{HttpStatusCode: http.StatusBadRequest, Message: "unknown driver"})
+			},
+		},
+		{
+			name: "internal error",
+			respond: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(ErrResponse{HttpStatusCode: http.StatusInternalServerError, Message: "boom"})
+			},
+			requeue: true,
+		},
+		{
+			name:    "worker restarting",
+			respond: func(w http.ResponseWriter) { http.NotFound(w, nil) },
+			requeue: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.respond(w)
+			}))
+			defer srv.Close()
+			worker := strings.TrimPrefix(srv.URL, "http://")
+
+			m := New([]string{worker}, "roundrobin", "memory")
+			tk := task.Task{ID: uuid.New(), Name: "web", Image: "nginx", ExposedPort: nat.PortSet{"80/tcp": {}}}
+			m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Running, Timestamp: time.Now(), Task: tk})
+			m.SendWork()
+
+			if n := m.workerNode(worker); n.PortsAllocated != 0 {
+				t.Errorf("the task holds %d host ports, want 0", n.PortsAllocated)
+			}
+			if _, ok := m.workerOf(tk.ID); ok && tt.requeue {
+				t.Error("the requeued task is still assigned to the worker")
+			}
+			_, requeued := m.dequeue()
+			if requeued != tt.requeue {
+				t.Errorf("requeued = %v, want %v", requeued, tt.requeue)
+			}
+			result, err := m.TaskDb.Get(tk.ID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := result.(*task.Task).State; !tt.requeue && got != task.Failed {
+				t.Errorf("rejected task is %v, want %v", got, task.Failed)
+			}
+		})
+	}
+}
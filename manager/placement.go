@@ -0,0 +1,57 @@
+package manager
+
+import (
+	"github.com/google/uuid"
+)
+
+// The manager's loops, its API handlers and its proxy all look up which worker
+// runs a task, so TaskWorkerMap, WorkerTaskMap and rescheduledFrom are only
+// accessed through these methods, under placementMu.
+
+// workerOf returns the worker the task is assigned to.
+func (m *Manager) workerOf(id uuid.UUID) (string, bool) {
+	m.placementMu.RLock()
+	defer m.placementMu.RUnlock()
+	w, ok := m.TaskWorkerMap[id]
+	return w, ok
+}
+
+// workerTasks returns the tasks assigned to worker w.
+func (m *Manager) workerTasks(w string) []uuid.UUID {
+	m.placementMu.RLock()
+	defer m.placementMu.RUnlock()
+	return append([]uuid.UUID(nil), m.WorkerTaskMap[w]...)
+}
+
+// assignTask records that the task runs on worker w, which ends a move from
+// another worker.
+func (m *Manager) assignTask(id uuid.UUID, w string) {
+	m.placementMu.Lock()
+	defer m.placementMu.Unlock()
+	m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], id)
+	m.TaskWorkerMap[id] = w
+	delete(m.rescheduledFrom, id)
+}
+
+// moveTaskOff unassigns the task from worker w until it is scheduled on another
+// worker; meanwhile w is where the task is moving from.
+func (m *Manager) moveTaskOff(id uuid.UUID, w string) {
+	m.placementMu.Lock()
+	defer m.placementMu.Unlock()
+	for i, tid := range m.WorkerTaskMap[w] {
+		if tid == id {
+			m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w][:i:i], m.WorkerTaskMap[w][i+1:]...)
+			break
+		}
+	}
+	delete(m.TaskWorkerMap, id)
+	m.rescheduledFrom[id] = w
+}
+
+// movingFrom returns the worker a rescheduled task is moving away from.
+func (m *Manager) movingFrom(id uuid.UUID) (string, bool) {
+	m.placementMu.RLock()
+	defer m.placementMu.RUnlock()
+	w, ok := m.rescheduledFrom[id]
+	return w, ok
+}
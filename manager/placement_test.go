@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPlacementConcurrentMoves(t *testing.T) {
+	m := New([]string{"a:1", "b:1"}, "roundrobin", "memory")
+	ids := make([]uuid.UUID, 50)
+	for i := range ids {
+		ids[i] = uuid.New()
+		m.assignTask(ids[i], "a:1")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for _, id := range ids {
+			m.moveTaskOff(id, "a:1")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for _, id := range ids {
+			m.assignTask(id, "b:1")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range ids {
+			m.workerTasks("a:1")
+			m.AddTask(task.TaskEvent{ID: uuid.New(), Task: task.Task{ID: uuid.New()}})
+			m.dequeue()
+		}
+	}()
+	wg.Wait()
+
+	if n := len(m.workerTasks("b:1")); n != len(ids) {
+		t.Errorf("worker b has %d tasks, want %d", n, len(ids))
+	}
+}
+
+func TestMoveTaskOff(t *testing.T) {
+	m := New([]string{"a:1", "b:1"}, "roundrobin", "memory")
+	id := uuid.New()
+	m.assignTask(id, "a:1")
+	m.moveTaskOff(id, "a:1")
+
+	if _, ok := m.workerOf(id); ok {
+		t.Errorf("task is still assigned after moving off its worker")
+	}
+	if from, ok := m.movingFrom(id); !ok || from != "a:1" {
+		t.Errorf("movingFrom = %q, %v, want a:1", from, ok)
+	}
+	m.assignTask(id, "b:1")
+	if _, ok := m.movingFrom(id); ok {
+		t.Errorf("task is still moving after being assigned to a new worker")
+	}
+}
+
+func TestRescheduleIgnoresOldWorker(t *testing.T) {
+	id := uuid.New()
+	var deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			deleted = r.URL.Path == "/tasks/"+id.String()
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			// the old worker still reports the run that was moved away
+			json.NewEncoder(w).Encode([]*task.Task{{ID: id, State: task.Completed, ContainerID: "old"}})
+		}
+	}))
+	defer srv.Close()
+	worker := strings.TrimPrefix(srv.URL, "http://")
+
+	m := New([]string{worker}, "roundrobin", "memory")
+	tk := &task.Task{ID: id, State: task.Failed, ContainerID: "old"}
+	m.TaskDb.Put(id.String(), tk)
+	m.assignTask(id, worker)
+
+	m.rescheduleTask(tk, worker, task.ReasonWorkerUnreachable, "worker is gone")
+	if !deleted {
+		t.Errorf("the container of the failed task was not removed from the old worker")
+	}
+
+	m.updateTasks()
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := result.(*task.Task)
+	if got.State != task.Scheduled || got.ContainerID != "" {
+		t.Errorf("got state %v container %q, want Scheduled without a container", got.State, got.ContainerID)
+	}
+}
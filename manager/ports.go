@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"cube/node"
+	"cube/task"
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"log"
+)
+
+// SetPortRange sets the range of host ports allocated to tasks on every worker.
+func (m *Manager) SetPortRange(min int, max int) {
+	for _, n := range m.WorkerNodes {
+		n.MinPort = min
+		n.MaxPort = max
+	}
+}
+
+func (m *Manager) workerNode(name string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// allocatePorts gives the task host ports on worker w for the ports it
+// publishes and records them in its HostPorts. The returned bindings are for the
+// copy of the task sent to the worker, the stored task keeps the ones it asked for.
+func (m *Manager) allocatePorts(t *task.Task, w string) (nat.PortMap, error) {
+	n := m.workerNode(w)
+	if n == nil {
+		return nil, fmt.Errorf("unknown worker %s", w)
+	}
+	bindings, err := n.AllocatePorts(*t)
+	if err != nil {
+		return nil, err
+	}
+	if len(bindings) > 0 {
+		t.HostPorts = bindings
+	}
+	return bindings, nil
+}
+
+// holdPorts records the host ports the task holds on worker w.
+func (m *Manager) holdPorts(t *task.Task, w string) {
+	if n := m.workerNode(w); n != nil {
+		if err := n.HoldPorts(*t); err != nil {
+			log.Printf("Task %s: %v\n", t.ID, err)
+		}
+	}
+}
+
+// releasePorts frees the host ports the task holds on its worker.
+func (m *Manager) releasePorts(t *task.Task) {
+	w, _ := m.workerOf(t.ID)
+	if n := m.workerNode(w); n != nil {
+		n.ReleasePorts(t.ID)
+	}
+}
+
+// onPortsTransition is the state machine hook freeing the host ports of a task
+// that ended or was lost, and holding them again for a lost task reported to be
+// running after all.
+func (m *Manager) onPortsTransition(t *task.Task, tr task.Transition) {
+	switch {
+	case portsReleased(tr.To):
+		m.releasePorts(t)
+	case tr.From == task.Lost && tr.To == task.Running:
+		if w, ok := m.workerOf(t.ID); ok {
+			m.holdPorts(t, w)
+		}
+	}
+}
+
+// portsReleased tells whether a task in state s holds no host ports.
+func portsReleased(s task.State) bool {
+	switch s {
+	case task.Completed, task.Failed, task.Lost, task.Cancelled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package manager
+
+import (
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestPortsFollowTaskState(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	n := m.workerNode("w1:1")
+	tk := &task.Task{ID: uuid.New(), State: task.Running, ExposedPort: nat.PortSet{"80/tcp": {}}}
+	m.assignTask(tk.ID, "w1:1")
+	if _, err := m.allocatePorts(tk, "w1:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.StateMachine.Transition(tk, task.Lost, task.ReasonWorkerUnreachable, "")
+	if n.PortsAllocated != 0 {
+		t.Errorf("a lost task holds %d host ports, want 0", n.PortsAllocated)
+	}
+	m.StateMachine.Transition(tk, task.Running, task.ReasonWorkerReported, "")
+	if n.PortsAllocated != 1 {
+		t.Errorf("a lost task reported running again holds %d host ports, want 1", n.PortsAllocated)
+	}
+	m.StateMachine.Transition(tk, task.Completed, task.ReasonExited, "")
+	if n.PortsAllocated != 0 {
+		t.Errorf("a completed task holds %d host ports, want 0", n.PortsAllocated)
+	}
+}
+
+func TestRestoreTasksHoldsStoredPorts(t *testing.T) {
+	id := uuid.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*task.Task{{ID: id, State: task.Running}})
+	}))
+	defer srv.Close()
+	worker := strings.TrimPrefix(srv.URL, "http://")
+
+	// a manager that restarted only has what it stored
+	m := New([]string{worker}, "roundrobin", "memory")
+	m.TaskDb.Put(id.String(), &task.Task{
+		ID:          id,
+		State:       task.Running,
+		ExposedPort: nat.PortSet{"80/tcp": {}},
+		HostPorts:   nat.PortMap{"80/tcp": {{HostPort: "30000"}}},
+	})
+	m.RestoreTasks()
+
+	if w, ok := m.workerOf(id); !ok || w != worker {
+		t.Errorf("workerOf = %q, %v, want %s", w, ok, worker)
+	}
+	other := &task.Task{ID: uuid.New(), ExposedPort: nat.PortSet{"80/tcp": {}}}
+	got, err := m.allocatePorts(other, worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hp := got["80/tcp"][0].HostPort; hp == "30000" {
+		t.Errorf("host port 30000 of the restored task was given to another task")
+	}
+}
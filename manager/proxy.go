@@ -0,0 +1,191 @@
+package manager
+
+import (
+	"context"
+	"cube/task"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyEjectTime is how long a task that could not be reached gets no requests
+// from the proxy, until its worker reports it unhealthy or it is reachable again.
+const proxyEjectTime = 10 * time.Second
+
+// Proxy is the manager's HTTP reverse proxy. It sends each request to one of
+// the tasks selected by the route matching it, see task.Route.
+type Proxy struct {
+	Manager *Manager
+
+	mu      sync.Mutex
+	next    map[string]int
+	active  map[uuid.UUID]int
+	ejected map[uuid.UUID]time.Time
+}
+
+func NewProxy(m *Manager) *Proxy {
+	return &Proxy{
+		Manager: m,
+		next:    make(map[string]int),
+		active:  make(map[uuid.UUID]int),
+		ejected: make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	route := p.Manager.matchRoute(strings.ToLower(host), r.URL.Path)
+	if route == nil {
+		http.Error(w, fmt.Sprintf("no route for %s%s", r.Host, r.URL.Path), http.StatusNotFound)
+		return
+	}
+
+	endpoints := p.Manager.readyEndpoints(func(t *task.Task) bool {
+		return route.Selects(t) && t.Liveness.Status != task.ProbeFailing
+	})
+	e, addr, ok := p.pick(route, endpoints)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no healthy task for route %s", route.Name), http.StatusServiceUnavailable)
+		return
+	}
+	defer p.done(e.Task.ID)
+
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = addr
+			if route.StripPrefix && route.PathPrefix != "" {
+				req.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(route.PathPrefix, "/")), "/")
+				req.URL.RawPath = ""
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("[proxy] Error sending request to task %s at %s: %v\n", e.Task.ID, addr, err)
+			if upstreamError(req, err) {
+				p.eject(e.Task.ID)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	rp.ServeHTTP(w, r)
+}
+
+// pick chooses the task the request goes to among the endpoints publishing the
+// route's port, following the route's balancer, and counts the request as active
+// on it until done is called. Tasks that could not be reached lately are only
+// picked when no other task is left.
+func (p *Proxy) pick(route *task.Route, endpoints []endpoint) (endpoint, string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var healthy, ejected []endpoint
+	var addrs, ejectedAddrs []string
+	now := time.Now()
+	for _, e := range endpoints {
+		port, ok := routePort(e, route.Port)
+		if !ok {
+			continue
+		}
+		addr := net.JoinHostPort(e.IP.String(), strconv.Itoa(port))
+		if until, ok := p.ejected[e.Task.ID]; ok && now.Before(until) {
+			ejected = append(ejected, e)
+			ejectedAddrs = append(ejectedAddrs, addr)
+			continue
+		}
+		delete(p.ejected, e.Task.ID)
+		healthy = append(healthy, e)
+		addrs = append(addrs, addr)
+	}
+	if len(healthy) == 0 {
+		healthy, addrs = ejected, ejectedAddrs
+	}
+	if len(healthy) == 0 {
+		return endpoint{}, "", false
+	}
+
+	i := 0
+	switch route.Balancer {
+	case task.BalanceLeastConnections:
+		for j, e := range healthy {
+			if p.active[e.Task.ID] < p.active[healthy[i].Task.ID] {
+				i = j
+			}
+		}
+	default:
+		i = p.next[route.Name] % len(healthy)
+		p.next[route.Name] = i + 1
+	}
+	p.active[healthy[i].Task.ID]++
+	return healthy[i], addrs[i], true
+}
+
+func (p *Proxy) done(id uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[id]--
+	if p.active[id] <= 0 {
+		delete(p.active, id)
+	}
+}
+
+func (p *Proxy) eject(id uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for other, until := range p.ejected {
+		if now.After(until) {
+			delete(p.ejected, other)
+		}
+	}
+	p.ejected[id] = now.Add(proxyEjectTime)
+}
+
+// upstreamError tells whether the request failed because the task could not be
+// reached or broke off the response, rather than because the client went away.
+func upstreamError(req *http.Request, err error) bool {
+	if errors.Is(err, context.Canceled) || req.Context().Err() != nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// routePort returns the host port the endpoint's container port is published
+// on, the first published port for 0.
+func routePort(e endpoint, containerPort int) (int, bool) {
+	if containerPort == 0 {
+		if len(e.Ports) == 0 {
+			return 0, false
+		}
+		return e.Ports[0], true
+	}
+	if e.Task.NetworkMode.IsHost() {
+		return containerPort, true
+	}
+	for p, bindings := range e.Task.HostPorts {
+		if p.Int() != containerPort {
+			continue
+		}
+		for _, b := range bindings {
+			if port, err := strconv.Atoi(b.HostPort); err == nil && port != 0 {
+				return port, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"context"
+	"cube/task"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// proxyTo returns a manager whose proxy routes /app to a ready task published
+// on the given host port of the local worker.
+func proxyTo(t *testing.T, port int) (*Manager, *Proxy, uuid.UUID) {
+	t.Helper()
+	m := New([]string{"127.0.0.1:5556"}, "roundrobin", "memory")
+	if err := m.AddRoute(&task.Route{Name: "app", PathPrefix: "/app", TaskName: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	tk := &task.Task{
+		ID:        uuid.New(),
+		Name:      "app",
+		State:     task.Running,
+		Ready:     true,
+		HostPorts: nat.PortMap{"80/tcp": {{HostPort: strconv.Itoa(port)}}},
+	}
+	m.TaskDb.Put(tk.ID.String(), tk)
+	m.assignTask(tk.ID, "127.0.0.1:5556")
+	m.refreshEndpoints()
+	return m, NewProxy(m), tk.ID
+}
+
+func serverPort(t *testing.T, srv *httptest.Server) int {
+	t.Helper()
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func ejected(p *Proxy, id uuid.UUID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.ejected[id]
+	return ok
+}
+
+func TestProxyRoutesSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	m, p, _ := proxyTo(t, serverPort(t, srv))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/app/x", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "/app/x" {
+		t.Errorf("got %d %q, want 200 /app/x", rec.Code, rec.Body.String())
+	}
+
+	if err := m.DeleteRoute("app"); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/app/x", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got %d after the route was deleted, want 404", rec.Code)
+	}
+}
+
+func TestProxyEjectsUnreachableTask(t *testing.T) {
+	srv := httptest.NewServer(nil)
+	port := serverPort(t, srv)
+	srv.Close()
+	_, p, id := proxyTo(t, port)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/app", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("got %d, want 502", rec.Code)
+	}
+	if !ejected(p, id) {
+		t.Errorf("a task refusing connections was not ejected")
+	}
+}
+
+func TestProxyKeepsTaskWhenClientCancels(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	_, p, id := proxyTo(t, serverPort(t, srv))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/app", nil).WithContext(ctx))
+	if ejected(p, id) {
+		t.Errorf("the task was ejected because the client went away")
+	}
+}
@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"cube/task"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// AddRegistryAuth stores the credentials for a registry, the password encrypted
+// with the secrets key, replacing the ones the registry had.
+func (m *Manager) AddRegistryAuth(a task.RegistryAuth) error {
+	m.secretsMu.Lock()
+	if m.secretCipher == nil {
+		m.secretsMu.Unlock()
+		return errors.New("no secrets key is configured, registry credentials are encrypted with it")
+	}
+	ciphertext, err := m.secretCipher.Seal(registryKey(a.Server), a.Password)
+	m.secretsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	m.registriesMu.Lock()
+	defer m.registriesMu.Unlock()
+	c := task.RegistryCredentials{
+		Server:       a.Server,
+		Username:     a.Username,
+		Ciphertext:   ciphertext,
+		CreationTime: time.Now(),
+	}
+	return m.RegistryDb.Put(a.Server, &c)
+}
+
+func (m *Manager) RemoveRegistryAuth(server string) bool {
+	m.registriesMu.Lock()
+	defer m.registriesMu.Unlock()
+	if _, err := m.RegistryDb.Get(server); err != nil {
+		return false
+	}
+	if err := m.RegistryDb.Delete(server); err != nil {
+		log.Printf("Error removing credentials for registry %s: %v\n", server, err)
+		return false
+	}
+	return true
+}
+
+// GetRegistries lists the registries the manager has credentials for, without the passwords.
+func (m *Manager) GetRegistries() []task.RegistryAuth {
+	m.registriesMu.Lock()
+	defer m.registriesMu.Unlock()
+	result, err := m.RegistryDb.List()
+	if err != nil {
+		log.Printf("Error getting list of registries: %v\n", err)
+		return nil
+	}
+	var registries []task.RegistryAuth
+	for _, c := range result.([]*task.RegistryCredentials) {
+		registries = append(registries, task.RegistryAuth{Server: c.Server, Username: c.Username})
+	}
+	return registries
+}
+
+// withRegistryAuths returns a copy of the event carrying the decrypted
+// credentials for the registries the images of the task and of its group come
+// from, ready to be sent to a worker.
+func (m *Manager) withRegistryAuths(te task.TaskEvent) (task.TaskEvent, error) {
+	images := []string{te.Task.Image}
+	for _, c := range te.Task.Containers {
+		images = append(images, c.Image)
+	}
+
+	var stored []task.RegistryCredentials
+	seen := make(map[string]bool)
+	m.registriesMu.Lock()
+	for _, image := range images {
+		// a task of the exec driver has no image to pull
+		if image == "" || seen[task.Registry(image)] {
+			continue
+		}
+		seen[task.Registry(image)] = true
+		if result, err := m.RegistryDb.Get(task.Registry(image)); err == nil {
+			stored = append(stored, *result.(*task.RegistryCredentials))
+		}
+	}
+	m.registriesMu.Unlock()
+	if len(stored) == 0 {
+		return te, nil
+	}
+
+	m.secretsMu.Lock()
+	defer m.secretsMu.Unlock()
+	if m.secretCipher == nil {
+		return te, errors.New("registry credentials are stored but no secrets key is configured")
+	}
+	var auths []task.RegistryAuth
+	for _, c := range stored {
+		password, err := m.secretCipher.Open(registryKey(c.Server), c.Ciphertext)
+		if err != nil {
+			return te, fmt.Errorf("credentials for registry %s: %v", c.Server, err)
+		}
+		auths = append(auths, task.RegistryAuth{Server: c.Server, Username: c.Username, Password: password})
+	}
+	te.RegistryAuths = auths
+	return te, nil
+}
+
+// registryKey is what a registry's password is sealed under, which no secret
+// name can be, so the ciphertexts of secrets and registries cannot be swapped.
+func registryKey(server string) string {
+	return "registry/" + server
+}
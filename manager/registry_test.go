@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAddRegistryAuthNeedsSecretsKey(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	err := m.AddRegistryAuth(task.RegistryAuth{Server: "registry.example.com", Username: "cube", Password: "hunter2"})
+	if err == nil {
+		t.Fatal("credentials stored without a secrets key")
+	}
+	if len(m.GetRegistries()) != 0 {
+		t.Errorf("registries = %+v, want none", m.GetRegistries())
+	}
+}
+
+func TestRegistryAuthsStoredEncrypted(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	if err := m.SetSecretsKey(bytes.Repeat([]byte("k"), 32)); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []task.RegistryAuth{
+		{Server: "registry.example.com", Username: "cube", Password: "hunter2"},
+		{Server: task.DefaultRegistry, Username: "hub", Password: "swordfish"},
+		{Server: "unused.example.com", Username: "other", Password: "letmein"},
+	} {
+		if err := m.AddRegistryAuth(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := m.RegistryDb.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(result)
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Errorf("stored credentials contain the password: %s", data)
+	}
+	data, _ = json.Marshal(m.GetRegistries())
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Errorf("listed registries contain the password: %s", data)
+	}
+
+	tests := []struct {
+		name string
+		task task.Task
+		want []string
+	}{
+		{name: "private image", task: task.Task{Image: "registry.example.com/app:1"}, want: []string{"hunter2"}},
+		{
+			name: "group pulling from two registries",
+			task: task.Task{
+				Image:      "registry.example.com/app:1",
+				Containers: []task.Container{{Name: "proxy", Image: "nginx"}, {Name: "sidecar", Image: "registry.example.com/sidecar"}},
+			},
+			want: []string{"hunter2", "swordfish"},
+		},
+		{name: "exec task", task: task.Task{Driver: "exec"}},
+		{name: "registry without credentials", task: task.Task{Image: "quay.io/app"}},
+	}
+	for _, tt := range tests {
+		te, err := m.withTaskData(task.TaskEvent{Task: tt.task})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, a := range te.RegistryAuths {
+			got = append(got, a.Password)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: sent passwords %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if !m.RemoveRegistryAuth("registry.example.com") {
+		t.Fatal("credentials not removed")
+	}
+	te, _ := m.withTaskData(task.TaskEvent{Task: task.Task{Image: "registry.example.com/app:1"}})
+	if len(te.RegistryAuths) != 0 {
+		t.Errorf("removed credentials still sent: %+v", te.RegistryAuths)
+	}
+}
+
+func TestSendWorkSendsRegistryAuthsOnlyToWorker(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		te := task.TaskEvent{}
+		json.Unmarshal(body, &te)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(te.Task)
+	}))
+	defer srv.Close()
+	worker := strings.TrimPrefix(srv.URL, "http://")
+
+	m := New([]string{worker}, "roundrobin", "memory")
+	if err := m.SetSecretsKey(bytes.Repeat([]byte("k"), 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRegistryAuth(task.RegistryAuth{Server: "registry.example.com", Username: "cube", Password: "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	tk := task.Task{ID: uuid.New(), Name: "app", Image: "registry.example.com/app:1"}
+	m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Running, Timestamp: time.Now(), Task: tk})
+	m.SendWork()
+
+	if !bytes.Contains(body, []byte("hunter2")) {
+		t.Errorf("the worker got no credentials: %s", body)
+	}
+	result, err := m.EventDb.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := json.Marshal(result)
+	result, err = m.TaskDb.Get(tk.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedTask, _ := json.Marshal(result)
+	if bytes.Contains(stored, []byte("hunter2")) || bytes.Contains(storedTask, []byte("hunter2")) {
+		t.Errorf("the password was stored with the task or its events")
+	}
+}
+
+func TestPullPolicyValidatedOnSubmission(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	bad := task.Task{Image: "app", ImagePullPolicy: "Sometimes"}
+
+	if err := m.AddService(&task.Service{Name: "web", Replicas: 1, Template: bad}); err == nil {
+		t.Error("service with an unknown pull policy accepted")
+	}
+	if err := m.AddCronJob(&task.CronJob{Name: "nightly", Schedule: "@daily", Task: bad}); err == nil {
+		t.Error("cron job with an unknown pull policy accepted")
+	}
+	if err := m.AddWorkflow(&Workflow{Name: "etl", Steps: []*WorkflowStep{{Name: "fetch", Task: bad}}}); err == nil {
+		t.Error("workflow with an unknown pull policy accepted")
+	}
+	group := task.Task{Image: "app", Containers: []task.Container{{Name: "proxy", Image: "nginx", ImagePullPolicy: "Sometimes"}}}
+	if err := m.AddService(&task.Service{Name: "group", Replicas: 1, Template: group}); err == nil {
+		t.Error("service with a container with an unknown pull policy accepted")
+	}
+
+	api := Api{Manager: m}
+	data, _ := json.Marshal(task.TaskEvent{ID: uuid.New(), State: task.Running, Task: bad})
+	rec := httptest.NewRecorder()
+	api.StartTaskHandler(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(data)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /tasks with an unknown pull policy: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"log"
+)
+
+// AddRoute adds a route to the proxy, or replaces the route with the same name.
+func (m *Manager) AddRoute(r *task.Route) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	m.routesMu.Lock()
+	if err := m.RouteDb.Put(r.Name, r); err != nil {
+		m.routesMu.Unlock()
+		return err
+	}
+	m.routesMu.Unlock()
+	log.Printf("Stored route %s\n", r.Name)
+	m.refreshRoutes()
+	return nil
+}
+
+func (m *Manager) GetRoutes() []*task.Route {
+	m.routesMu.Lock()
+	defer m.routesMu.Unlock()
+	result, err := m.RouteDb.List()
+	if err != nil {
+		log.Printf("Error getting list of routes: %v\n", err)
+		return nil
+	}
+	var routes []*task.Route
+	for _, r := range result.([]*task.Route) {
+		route := *r
+		routes = append(routes, &route)
+	}
+	return routes
+}
+
+func (m *Manager) DeleteRoute(name string) error {
+	m.routesMu.Lock()
+	if _, err := m.RouteDb.Get(name); err != nil {
+		m.routesMu.Unlock()
+		return fmt.Errorf("route %s not found", name)
+	}
+	err := m.RouteDb.Delete(name)
+	m.routesMu.Unlock()
+	m.refreshRoutes()
+	return err
+}
+
+// refreshRoutes takes the snapshot of the routes the proxy matches requests
+// against. Routes are added and deleted through the API, which refreshes it;
+// the update loop refreshes it too, to pick up the stored routes at startup.
+func (m *Manager) refreshRoutes() {
+	routes := m.GetRoutes()
+	m.endpointsMu.Lock()
+	defer m.endpointsMu.Unlock()
+	m.routes = routes
+}
+
+// matchRoute returns the route taking a request for host and path: a route for
+// the host wins over one for any host, then the longest path prefix wins.
+func (m *Manager) matchRoute(host string, path string) *task.Route {
+	m.endpointsMu.RLock()
+	defer m.endpointsMu.RUnlock()
+	var best *task.Route
+	for _, r := range m.routes {
+		if !r.Matches(host, path) {
+			continue
+		}
+		if best == nil || moreSpecific(r, best) {
+			best = r
+		}
+	}
+	return best
+}
+
+func moreSpecific(r *task.Route, than *task.Route) bool {
+	if (r.Host != "") != (than.Host != "") {
+		return r.Host != ""
+	}
+	if len(r.PathPrefix) != len(than.PathPrefix) {
+		return len(r.PathPrefix) > len(than.PathPrefix)
+	}
+	return r.Name < than.Name
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"cube/task"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SetSecretsKey enables secrets, encrypted with key; without a key the manager
+// refuses to store secrets and to start tasks referencing them.
+func (m *Manager) SetSecretsKey(key []byte) error {
+	c, err := task.NewSecretCipher(key)
+	if err != nil {
+		return err
+	}
+	m.secretsMu.Lock()
+	defer m.secretsMu.Unlock()
+	m.secretCipher = c
+	return nil
+}
+
+// AddSecret stores the value encrypted under name, replacing the previous value
+// of a secret with that name. Running tasks keep the value they were started with.
+func (m *Manager) AddSecret(name string, value string) (*task.Secret, error) {
+	if !task.ValidSecretName(name) {
+		return nil, fmt.Errorf("invalid secret name %q", name)
+	}
+
+	m.secretsMu.Lock()
+	defer m.secretsMu.Unlock()
+	if m.secretCipher == nil {
+		return nil, errors.New("no secrets key is configured")
+	}
+	ciphertext, err := m.secretCipher.Seal(name, value)
+	if err != nil {
+		return nil, err
+	}
+	s := task.Secret{Name: name, Ciphertext: ciphertext, CreationTime: time.Now()}
+	if err := m.SecretDb.Put(name, &s); err != nil {
+		return nil, err
+	}
+	log.Printf("Stored secret %s\n", name)
+	return &task.Secret{Name: s.Name, CreationTime: s.CreationTime}, nil
+}
+
+// GetSecrets lists the secrets without their values.
+func (m *Manager) GetSecrets() []*task.Secret {
+	m.secretsMu.Lock()
+	defer m.secretsMu.Unlock()
+	result, err := m.SecretDb.List()
+	if err != nil {
+		log.Printf("Error getting list of secrets: %v\n", err)
+		return nil
+	}
+	var secrets []*task.Secret
+	for _, s := range result.([]*task.Secret) {
+		secrets = append(secrets, &task.Secret{Name: s.Name, CreationTime: s.CreationTime})
+	}
+	return secrets
+}
+
+func (m *Manager) DeleteSecret(name string) error {
+	m.secretsMu.Lock()
+	defer m.secretsMu.Unlock()
+	if _, err := m.SecretDb.Get(name); err != nil {
+		return err
+	}
+	return m.SecretDb.Delete(name)
+}
+
+// withSecrets returns a copy of the event carrying the decrypted values of the
+// secrets the task references, ready to be sent to a worker.
+func (m *Manager) withSecrets(te task.TaskEvent) (task.TaskEvent, error) {
+	if len(te.Task.Secrets) == 0 {
+		return te, nil
+	}
+
+	m.secretsMu.Lock()
+	defer m.secretsMu.Unlock()
+	if m.secretCipher == nil {
+		return te, errors.New("the task references secrets but no secrets key is configured")
+	}
+	values := make(map[string]string)
+	for _, ref := range te.Task.Secrets {
+		if _, ok := values[ref.Name]; ok {
+			continue
+		}
+		result, err := m.SecretDb.Get(ref.Name)
+		if err != nil {
+			return te, fmt.Errorf("secret %s not found", ref.Name)
+		}
+		s := result.(*task.Secret)
+		value, err := m.secretCipher.Open(s.Name, s.Ciphertext)
+		if err != nil {
+			return te, err
+		}
+		values[ref.Name] = value
+	}
+	te.Secrets = values
+	return te, nil
+}
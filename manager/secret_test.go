@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSendWorkRequeueKeepsSecretsOut(t *testing.T) {
+	// a worker that is gone by the time the manager sends it the task
+	srv := httptest.NewServer(nil)
+	worker := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	m := New([]string{worker}, "roundrobin", "memory")
+	if err := m.SetSecretsKey(bytes.Repeat([]byte("k"), 32)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddSecret("db-password", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task: task.Task{
+			ID:      uuid.New(),
+			Name:    "db",
+			Image:   "postgres",
+			Secrets: []task.SecretRef{{Name: "db-password", Env: "PASSWORD"}},
+		},
+	})
+	// the failed POST requeues the event, which the second call stores again
+	m.SendWork()
+	m.SendWork()
+
+	result, err := m.EventDb.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := result.([]*task.TaskEvent)
+	if len(events) != 2 {
+		t.Fatalf("got %d stored events, want 2", len(events))
+	}
+	for _, te := range events {
+		data, err := json.Marshal(te)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("hunter2")) {
+			t.Errorf("stored event %s contains the secret value: %s", te.ID, data)
+		}
+	}
+	if _, ok := m.workerOf(events[0].Task.ID); ok {
+		t.Errorf("task is still assigned to the worker it could not be sent to")
+	}
+}
+
+func TestStartTaskHandlerRejectsExecTaskWithFileSecret(t *testing.T) {
+	api := Api{Manager: New([]string{"w1:1"}, "roundrobin", "memory")}
+	tk := task.Task{
+		ID:      uuid.New(),
+		Name:    "job",
+		Driver:  "exec",
+		Cmd:     []string{"true"},
+		Secrets: []task.SecretRef{{Name: "db", File: "/run/secrets/db"}},
+	}
+	data, _ := json.Marshal(task.TaskEvent{ID: uuid.New(), State: task.Running, Task: tk})
+	rec := httptest.NewRecorder()
+	api.StartTaskHandler(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(data)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
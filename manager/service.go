@@ -0,0 +1,409 @@
+package manager
+
+import (
+	"cube/task"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+func (m *Manager) AddService(s *task.Service) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := m.CheckResources(s.Template); err != nil {
+		return fmt.Errorf("task template cannot be enforced on any worker: %v", err)
+	}
+
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	if _, err := m.ServiceDb.Get(s.Name); err == nil {
+		return fmt.Errorf("service %s already exists", s.Name)
+	}
+	s.CreationTime = time.Now()
+	s.Revision = 1
+	s.History = nil
+	s.UpdateState = ""
+	s.UpdateMessage = ""
+	s.Tasks = nil
+	s.RunningReplicas = 0
+	s.ReadyReplicas = 0
+	m.reconcileService(s)
+	return m.ServiceDb.Put(s.Name, s)
+}
+
+func (m *Manager) GetServices() []*task.Service {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	result, err := m.ServiceDb.List()
+	if err != nil {
+		log.Printf("Error getting list of services: %v\n", err)
+		return nil
+	}
+	var services []*task.Service
+	for _, s := range result.([]*task.Service) {
+		service := *s
+		services = append(services, &service)
+	}
+	return services
+}
+
+func (m *Manager) GetService(name string) (*task.Service, error) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	result, err := m.ServiceDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	service := *result.(*task.Service)
+	return &service, nil
+}
+
+// ScaleService changes the desired replica count and converges right away
+// instead of waiting for the next reconciliation.
+func (m *Manager) ScaleService(name string, replicas int) (*task.Service, error) {
+	if replicas < 0 {
+		return nil, fmt.Errorf("replica count must not be negative")
+	}
+
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	result, err := m.ServiceDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	s := result.(*task.Service)
+	log.Printf("Scaling service %s from %d to %d replicas\n", s.Name, s.Replicas, replicas)
+	s.Replicas = replicas
+	m.reconcileService(s)
+	if err := m.ServiceDb.Put(s.Name, s); err != nil {
+		return nil, err
+	}
+	service := *s
+	return &service, nil
+}
+
+// UpdateService makes template the service's new revision and starts rolling it
+// out; a nil cfg keeps the service's update configuration.
+func (m *Manager) UpdateService(name string, template task.Task, cfg *task.UpdateConfig) (*task.Service, error) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	result, err := m.ServiceDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	s := *result.(*task.Service)
+	s.Template = template
+	if cfg != nil {
+		s.UpdateConfig = *cfg
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if err := m.CheckResources(template); err != nil {
+		return nil, fmt.Errorf("task template cannot be enforced on any worker: %v", err)
+	}
+
+	// the copy was only used for validation, the update starts from the stored service
+	updated := result.(*task.Service)
+	updated.UpdateConfig = s.UpdateConfig
+	updated.Update(template)
+	log.Printf("Service %s: %s\n", updated.Name, updated.UpdateMessage)
+	m.reconcileService(updated)
+	if err := m.ServiceDb.Put(updated.Name, updated); err != nil {
+		return nil, err
+	}
+	service := *updated
+	return &service, nil
+}
+
+// RollbackService restores the previous revision of the service and rolls it
+// out, which also resumes a paused update.
+func (m *Manager) RollbackService(name string) (*task.Service, error) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	result, err := m.ServiceDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	s := result.(*task.Service)
+	if err := s.Rollback(); err != nil {
+		return nil, err
+	}
+	log.Printf("Service %s: %s\n", s.Name, s.UpdateMessage)
+	m.reconcileService(s)
+	if err := m.ServiceDb.Put(s.Name, s); err != nil {
+		return nil, err
+	}
+	service := *s
+	return &service, nil
+}
+
+// DeleteService stops every task of the service and removes it.
+func (m *Manager) DeleteService(name string) error {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	result, err := m.ServiceDb.Get(name)
+	if err != nil {
+		return err
+	}
+	s := result.(*task.Service)
+	for _, id := range s.Tasks {
+		m.stopServiceTask(s, id)
+	}
+	return m.ServiceDb.Delete(name)
+}
+
+func (m *Manager) ReconcileServices() {
+	for {
+		log.Println("Reconciling services")
+		m.reconcileServices()
+		log.Println("Sleeping for 15 seconds")
+		time.Sleep(15 * time.Second)
+	}
+}
+
+func (m *Manager) reconcileServices() {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	result, err := m.ServiceDb.List()
+	if err != nil {
+		log.Printf("Error getting list of services: %v\n", err)
+		return
+	}
+	for _, s := range result.([]*task.Service) {
+		m.reconcileService(s)
+		if err := m.ServiceDb.Put(s.Name, s); err != nil {
+			log.Printf("Error storing service %s: %v\n", s.Name, err)
+		}
+	}
+}
+
+// reconcileService compares the tasks of the service with its replica count and
+// submits or stops tasks to converge. Finished tasks are forgotten, so a failed
+// task is replaced by a new one. While tasks of an older revision, or running
+// an older version of a config than the latest, are left, the service is rolled
+// to the current one instead. It must be called with servicesMu held.
+func (m *Manager) reconcileService(s *task.Service) {
+	var tasks []uuid.UUID
+	var current, old []*task.Task
+	running, ready := 0, 0
+	latest := m.latestConfigVersions()
+	for _, id := range s.Tasks {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			log.Printf("Task %v of service %s not found, dropping it\n", id, s.Name)
+			continue
+		}
+		t := result.(*task.Task)
+		isCurrent := task.TaskRevision(t) == s.Revision
+		switch t.State {
+		case task.Completed, task.Failed, task.Cancelled, task.Stopping:
+			if t.State == task.Failed && isCurrent && s.UpdateState == task.UpdateProgressing {
+				m.pauseUpdate(s, fmt.Sprintf("task %s of revision %d failed", t.ID, s.Revision))
+			}
+			continue
+		case task.Running:
+			running++
+			if t.Ready {
+				ready++
+			}
+		}
+		tasks = append(tasks, id)
+		if name, outdated := task.ConfigOutdated(t, latest); isCurrent && outdated {
+			isCurrent = false
+			if s.UpdateState != task.UpdateProgressing && s.UpdateState != task.UpdatePaused {
+				s.UpdateState = task.UpdateProgressing
+				s.UpdateMessage = fmt.Sprintf("rolling to version %d of config %s", latest[name], name)
+				log.Printf("Service %s: %s\n", s.Name, s.UpdateMessage)
+			}
+		}
+		if isCurrent {
+			current = append(current, t)
+		} else {
+			old = append(old, t)
+		}
+	}
+	s.Tasks = tasks
+	s.RunningReplicas = running
+	s.ReadyReplicas = ready
+
+	if len(old) == 0 {
+		if s.UpdateState == task.UpdateProgressing {
+			s.UpdateState = task.UpdateCompleted
+			s.UpdateMessage = fmt.Sprintf("revision %d rolled out", s.Revision)
+			log.Printf("Service %s: %s\n", s.Name, s.UpdateMessage)
+		}
+		m.scaleService(s, aliveTasks(current))
+		return
+	}
+	if s.UpdateState == task.UpdatePaused {
+		return
+	}
+	m.rollService(s, current, old)
+}
+
+// scaleService submits or stops tasks of the current revision until as many as
+// the service's replica count are alive.
+func (m *Manager) scaleService(s *task.Service, alive []uuid.UUID) {
+	for i := len(alive); i < s.Replicas; i++ {
+		m.submitServiceTask(s)
+	}
+
+	if excess := len(alive) - s.Replicas; excess > 0 {
+		for _, id := range m.pickTasksToStop(alive, excess) {
+			m.stopServiceTask(s, id)
+		}
+	}
+}
+
+// rollService moves the service one step closer to running only tasks of its
+// current revision: it starts new tasks as long as MaxSurge allows and stops
+// old ones as long as MaxUnavailable allows, counting a new task as available
+// only once it is ready.
+func (m *Manager) rollService(s *task.Service, current []*task.Task, old []*task.Task) {
+	cfg := s.UpdateConfig.WithDefaults()
+	newAlive, oldAlive := aliveTasks(current), aliveTasks(old)
+
+	ready := 0
+	for _, t := range current {
+		if t.State != task.Running {
+			continue
+		}
+		ok, err := m.taskReady(t)
+		if err != nil && time.Since(t.StartTime) > time.Duration(cfg.HealthCheckGraceSeconds)*time.Second {
+			m.pauseUpdate(s, fmt.Sprintf("task %s of revision %d fails its health check: %v", t.ID, s.Revision, err))
+			return
+		}
+		if ok {
+			ready++
+		}
+	}
+
+	create := s.Replicas + cfg.MaxSurge - len(newAlive) - len(oldAlive)
+	if max := s.Replicas - len(newAlive); create > max {
+		create = max
+	}
+	for i := 0; i < create; i++ {
+		m.submitServiceTask(s)
+	}
+	if excess := len(newAlive) - s.Replicas; excess > 0 {
+		for _, id := range m.pickTasksToStop(newAlive, excess) {
+			m.stopServiceTask(s, id)
+		}
+	}
+
+	stop := len(oldAlive) + ready - (s.Replicas - cfg.MaxUnavailable)
+	if stop > len(oldAlive) {
+		stop = len(oldAlive)
+	}
+	if stop > 0 {
+		for _, id := range m.pickTasksToStop(oldAlive, stop) {
+			m.stopServiceTask(s, id)
+		}
+	}
+	// the old tasks that are not alive, e.g. lost ones, go once no old task runs
+	if stop == len(oldAlive) {
+		for _, t := range old {
+			m.stopServiceTask(s, t.ID)
+		}
+	}
+}
+
+// taskReady reports whether a running task is ready and passes its liveness
+// probe, as last reported by its worker. A task whose probes have not reached a
+// threshold yet is not ready, without that being an error.
+func (m *Manager) taskReady(t *task.Task) (bool, error) {
+	if t.Liveness.Status == task.ProbeFailing {
+		return false, errors.New(t.Liveness.Message)
+	}
+	if t.Readiness.Status == task.ProbeFailing {
+		return false, errors.New(t.Readiness.Message)
+	}
+	if t.LivenessCheck() != nil && t.Liveness.Status != task.ProbePassing {
+		return false, nil
+	}
+	return t.Ready, nil
+}
+
+func (m *Manager) pauseUpdate(s *task.Service, message string) {
+	s.UpdateState = task.UpdatePaused
+	s.UpdateMessage = message
+	log.Printf("Service %s: update paused, %s\n", s.Name, message)
+}
+
+func (m *Manager) submitServiceTask(s *task.Service) {
+	t := s.NewTask()
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task:      t,
+	})
+	s.Tasks = append(s.Tasks, t.ID)
+	log.Printf("Service %s: submitted task %v of revision %d\n", s.Name, t.ID, s.Revision)
+}
+
+func aliveTasks(tasks []*task.Task) []uuid.UUID {
+	var alive []uuid.UUID
+	for _, t := range tasks {
+		switch t.State {
+		case task.Pending, task.Scheduled, task.Running, task.Restarting:
+			alive = append(alive, t.ID)
+		}
+	}
+	return alive
+}
+
+// pickTasksToStop chooses n of the tasks, the ones that are not running yet
+// first, then the newest ones.
+func (m *Manager) pickTasksToStop(ids []uuid.UUID, n int) []uuid.UUID {
+	var notRunning, running []uuid.UUID
+	for i := len(ids) - 1; i >= 0; i-- {
+		result, err := m.TaskDb.Get(ids[i].String())
+		if err != nil {
+			continue
+		}
+		if result.(*task.Task).State == task.Running {
+			running = append(running, ids[i])
+		} else {
+			notRunning = append(notRunning, ids[i])
+		}
+	}
+	candidates := append(notRunning, running...)
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	return candidates[:n]
+}
+
+// stopServiceTask stops the task. A task that has not started yet is cancelled
+// on the manager and leaves the service right away; a running one stays in it,
+// and is asked to stop again, until its worker reports it stopping.
+func (m *Manager) stopServiceTask(s *task.Service, id uuid.UUID) {
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		return
+	}
+	t := result.(*task.Task)
+	switch t.State {
+	case task.Completed, task.Failed, task.Cancelled, task.Stopping:
+		return
+	}
+	log.Printf("Service %s: stopping task %v\n", s.Name, t.ID)
+	if err := m.StopTask(t); err != nil {
+		log.Printf("Error stopping task %v of service %s: %v\n", t.ID, s.Name, err)
+		return
+	}
+	if t.State == task.Cancelled {
+		for i, taskID := range s.Tasks {
+			if taskID == id {
+				s.Tasks = append(s.Tasks[:i:i], s.Tasks[i+1:]...)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateServiceRollsOneTaskAtATime(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	s := &task.Service{Name: "web", Replicas: 2, Template: task.Task{Image: "web:1"}}
+	if err := m.AddService(s); err != nil {
+		t.Fatal(err)
+	}
+	startServiceTasks(t, m, "web")
+	m.reconcileServices()
+
+	if _, err := m.UpdateService("web", task.Task{Image: "web:2"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the default MaxSurge of 1 and MaxUnavailable of 0 keep 2 to 3 tasks, at
+	// least 2 of them running
+	for i := 0; i < 10; i++ {
+		s, _ := m.GetService("web")
+		if s.UpdateState == task.UpdateCompleted {
+			break
+		}
+		tasks := serviceTasks(t, m, "web")
+		running := 0
+		for _, tk := range tasks {
+			if tk.State == task.Running {
+				running++
+			}
+		}
+		if len(tasks) > 3 || running < 2 {
+			t.Fatalf("step %d: %d tasks, %d of them running, want at most 3 with 2 running", i, len(tasks), running)
+		}
+
+		startServiceTasks(t, m, "web")
+		m.reconcileServices()
+	}
+
+	s, _ = m.GetService("web")
+	if s.UpdateState != task.UpdateCompleted {
+		t.Fatalf("update is %s (%s), want %s", s.UpdateState, s.UpdateMessage, task.UpdateCompleted)
+	}
+	tasks := serviceTasks(t, m, "web")
+	if len(tasks) != 2 {
+		t.Fatalf("%d tasks after the update, want 2", len(tasks))
+	}
+	for _, tk := range tasks {
+		if tk.Image != "web:2" || task.TaskRevision(tk) != 2 {
+			t.Errorf("task %v runs %s of revision %d, want web:2 of revision 2", tk.ID, tk.Image, task.TaskRevision(tk))
+		}
+	}
+}
+
+func TestUpdateServicePausesOnFailedTask(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	s := &task.Service{Name: "web", Replicas: 2, Template: task.Task{Image: "web:1"}}
+	if err := m.AddService(s); err != nil {
+		t.Fatal(err)
+	}
+	startServiceTasks(t, m, "web")
+	if _, err := m.UpdateService("web", task.Task{Image: "web:2"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tk := range serviceTasks(t, m, "web") {
+		if task.TaskRevision(tk) != 2 {
+			continue
+		}
+		m.StateMachine.Transition(tk, task.Scheduled, task.ReasonScheduled, "")
+		m.StateMachine.Transition(tk, task.Failed, task.ReasonStartError, "no such image")
+	}
+	m.reconcileServices()
+	m.reconcileServices()
+
+	s, _ = m.GetService("web")
+	if s.UpdateState != task.UpdatePaused {
+		t.Fatalf("update is %s, want %s", s.UpdateState, task.UpdatePaused)
+	}
+	tasks := serviceTasks(t, m, "web")
+	if len(tasks) != 2 {
+		t.Fatalf("%d tasks, want the 2 old ones kept", len(tasks))
+	}
+	for _, tk := range tasks {
+		if task.TaskRevision(tk) != 1 || tk.State != task.Running {
+			t.Errorf("task %v is %s with revision %d, want the old running ones", tk.ID, tk.State, task.TaskRevision(tk))
+		}
+	}
+}
+
+func TestStopServiceTask(t *testing.T) {
+	var mu sync.Mutex
+	var reported []*task.Task
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/tasks/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(reported)
+	}))
+	defer srv.Close()
+	worker := strings.TrimPrefix(srv.URL, "http://")
+
+	m := New([]string{worker}, "roundrobin", "memory")
+	if err := m.AddService(&task.Service{Name: "web", Replicas: 3, Template: task.Task{Image: "web:1"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ok := m.dequeue(); ok; _, ok = m.dequeue() {
+	}
+	tasks := serviceTasks(t, m, "web")
+	pending, scheduled, running := tasks[0], tasks[1], tasks[2]
+	for _, tk := range []*task.Task{scheduled, running} {
+		m.StateMachine.Transition(tk, task.Scheduled, task.ReasonScheduled, "")
+		m.assignTask(tk.ID, worker)
+	}
+	m.StateMachine.Transition(running, task.Running, task.ReasonStarted, "")
+
+	result, err := m.ServiceDb.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := result.(*task.Service)
+	for _, tk := range tasks {
+		m.stopServiceTask(s, tk.ID)
+	}
+
+	for _, tk := range []*task.Task{pending, scheduled} {
+		if tk.State != task.Cancelled {
+			t.Errorf("task %v is %s, want it cancelled on the manager", tk.ID, tk.State)
+		}
+	}
+	if len(s.Tasks) != 1 || s.Tasks[0] != running.ID {
+		t.Errorf("service tasks = %v, want only the running task %v kept until it stops", s.Tasks, running.ID)
+	}
+	var stops []uuid.UUID
+	for te, ok := m.dequeue(); ok; te, ok = m.dequeue() {
+		if te.State == task.Completed {
+			stops = append(stops, te.Task.ID)
+		}
+	}
+	if len(stops) != 1 || stops[0] != running.ID {
+		t.Errorf("stop events for %v, want one for the running task %v", stops, running.ID)
+	}
+
+	// the worker started the scheduled task before it learnt it was cancelled
+	started := *scheduled
+	started.State = task.Running
+	mu.Lock()
+	reported = []*task.Task{&started}
+	mu.Unlock()
+	m.updateTasks()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deleted) != 1 || deleted[0] != scheduled.ID.String() {
+		t.Errorf("worker was asked to stop %v, want the cancelled task %v", deleted, scheduled.ID)
+	}
+	if scheduled.State != task.Cancelled {
+		t.Errorf("cancelled task is %s after the worker reported it running", scheduled.State)
+	}
+}
+
+func serviceTasks(t *testing.T, m *Manager, name string) []*task.Task {
+	t.Helper()
+	s, err := m.GetService(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tasks []*task.Task
+	for _, id := range s.Tasks {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, result.(*task.Task))
+	}
+	return tasks
+}
+
+// startServiceTasks plays the workers, starting the pending tasks of the service
+// and stopping the tasks the manager asked to stop.
+func startServiceTasks(t *testing.T, m *Manager, name string) {
+	t.Helper()
+	for te, ok := m.dequeue(); ok; te, ok = m.dequeue() {
+		if te.State != task.Completed {
+			continue
+		}
+		result, err := m.TaskDb.Get(te.Task.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.StateMachine.Transition(result.(*task.Task), task.Stopping, task.ReasonStopRequested, "")
+	}
+	for _, tk := range serviceTasks(t, m, name) {
+		if tk.State != task.Pending {
+			continue
+		}
+		m.StateMachine.Transition(tk, task.Scheduled, task.ReasonScheduled, "")
+		m.StateMachine.Transition(tk, task.Running, task.ReasonStarted, "")
+	}
+}
@@ -0,0 +1,396 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+type WorkflowState string
+
+const (
+	WorkflowRunning   WorkflowState = "Running"
+	WorkflowCompleted WorkflowState = "Completed"
+	WorkflowFailed    WorkflowState = "Failed"
+	WorkflowCancelled WorkflowState = "Cancelled"
+)
+
+type StepState string
+
+/*
+- Waiting: some upstream step has not completed yet.
+- Running: the step's task was handed to the manager, it is pending, scheduled or running.
+- Skipped: an upstream step failed or was cancelled, so the step will never run.
+*/
+const (
+	StepWaiting   StepState = "Waiting"
+	StepRunning   StepState = "Running"
+	StepCompleted StepState = "Completed"
+	StepFailed    StepState = "Failed"
+	StepSkipped   StepState = "Skipped"
+	StepCancelled StepState = "Cancelled"
+)
+
+// Workflow is a DAG of batch tasks. A step's task is only added to the Pending
+// queue once every step it depends on has completed; a step that fails after
+// its retries makes everything downstream of it skipped and the workflow fail,
+// while the branches that do not depend on it run to the end.
+type Workflow struct {
+	ID         uuid.UUID
+	Name       string
+	Steps      []*WorkflowStep
+	State      WorkflowState
+	StartTime  time.Time
+	FinishTime time.Time
+}
+
+type WorkflowStep struct {
+	Name      string
+	Task      task.Task
+	DependsOn []string
+	// MaxRetries is how many times a failed task is replaced by a new one before
+	// the step fails.
+	MaxRetries int
+	State      StepState
+	Message    string
+	// TaskID is the task of the latest attempt, Attempts counts them.
+	TaskID   uuid.UUID
+	Attempts int
+}
+
+// Validate checks the steps form a DAG and puts them in dependency order, so
+// upstream steps always come before the steps depending on them.
+func (wf *Workflow) Validate() error {
+	if len(wf.Steps) == 0 {
+		return fmt.Errorf("workflow %s has no steps", wf.Name)
+	}
+
+	steps := make(map[string]*WorkflowStep)
+	for _, s := range wf.Steps {
+		if s.Name == "" {
+			return fmt.Errorf("workflow %s has a step without a name", wf.Name)
+		}
+		if _, ok := steps[s.Name]; ok {
+			return fmt.Errorf("workflow %s has more than one step named %s", wf.Name, s.Name)
+		}
+		if s.Task.Kind == "" {
+			s.Task.Kind = task.KindBatch
+		}
+		if s.Task.Kind != task.KindBatch {
+			return fmt.Errorf("step %s is a %s task, workflow steps must be batch tasks", s.Name, s.Task.Kind)
+		}
+		if s.MaxRetries < 0 {
+			return fmt.Errorf("step %s has a negative MaxRetries", s.Name)
+		}
+		if err := task.ValidatePullPolicies(s.Task); err != nil {
+			return fmt.Errorf("step %s: %v", s.Name, err)
+		}
+		steps[s.Name] = s
+	}
+	for _, s := range wf.Steps {
+		for _, dep := range s.DependsOn {
+			if _, ok := steps[dep]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %s", s.Name, dep)
+			}
+		}
+	}
+
+	// depth first walk, a step seen again while it is still being visited closes a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var ordered []*WorkflowStep
+	var visit func(s *WorkflowStep) error
+	visit = func(s *WorkflowStep) error {
+		switch marks[s.Name] {
+		case visiting:
+			return fmt.Errorf("workflow %s has a dependency cycle through step %s", wf.Name, s.Name)
+		case visited:
+			return nil
+		}
+		marks[s.Name] = visiting
+		for _, dep := range s.DependsOn {
+			if err := visit(steps[dep]); err != nil {
+				return err
+			}
+		}
+		marks[s.Name] = visited
+		ordered = append(ordered, s)
+		return nil
+	}
+	for _, s := range wf.Steps {
+		if err := visit(s); err != nil {
+			return err
+		}
+	}
+	wf.Steps = ordered
+	return nil
+}
+
+func (wf *Workflow) step(name string) *WorkflowStep {
+	for _, s := range wf.Steps {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (wf *Workflow) stepForTask(id uuid.UUID) *WorkflowStep {
+	for _, s := range wf.Steps {
+		if s.TaskID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (wf *Workflow) finished() bool {
+	return wf.State != WorkflowRunning
+}
+
+// copy returns a snapshot of the workflow that is safe to use without holding
+// the manager's workflow lock.
+func (wf *Workflow) copy() *Workflow {
+	c := *wf
+	c.Steps = make([]*WorkflowStep, len(wf.Steps))
+	for i, s := range wf.Steps {
+		step := *s
+		c.Steps[i] = &step
+	}
+	return &c
+}
+
+// AddWorkflow validates the workflow and starts the steps that do not depend on
+// any other step.
+func (m *Manager) AddWorkflow(wf *Workflow) error {
+	if wf.ID == uuid.Nil {
+		wf.ID = uuid.New()
+	}
+	if wf.Name == "" {
+		wf.Name = wf.ID.String()
+	}
+
+	if err := wf.Validate(); err != nil {
+		return err
+	}
+	for _, s := range wf.Steps {
+		if err := m.CheckResources(s.Task); err != nil {
+			return fmt.Errorf("step %s cannot be enforced on any worker: %v", s.Name, err)
+		}
+	}
+
+	wf.State = WorkflowRunning
+	wf.StartTime = time.Now()
+	wf.FinishTime = time.Time{}
+	for _, s := range wf.Steps {
+		s.State = StepWaiting
+		s.Message = ""
+		s.TaskID = uuid.Nil
+		s.Attempts = 0
+	}
+
+	m.workflowsMu.Lock()
+	defer m.workflowsMu.Unlock()
+	if _, ok := m.Workflows[wf.ID]; ok {
+		return fmt.Errorf("workflow %s already exists", wf.ID)
+	}
+	m.Workflows[wf.ID] = wf
+	m.advanceWorkflow(wf)
+	return nil
+}
+
+func (m *Manager) GetWorkflows() []*Workflow {
+	m.workflowsMu.Lock()
+	defer m.workflowsMu.Unlock()
+	var workflows []*Workflow
+	for _, wf := range m.Workflows {
+		workflows = append(workflows, wf.copy())
+	}
+	return workflows
+}
+
+func (m *Manager) GetWorkflow(id uuid.UUID) (*Workflow, bool) {
+	m.workflowsMu.Lock()
+	defer m.workflowsMu.Unlock()
+	wf, ok := m.Workflows[id]
+	if !ok {
+		return nil, false
+	}
+	return wf.copy(), true
+}
+
+// CancelWorkflow stops the workflow: waiting steps never start and the tasks of
+// running steps are cancelled or stopped.
+func (m *Manager) CancelWorkflow(id uuid.UUID) error {
+	m.workflowsMu.Lock()
+	wf, ok := m.Workflows[id]
+	if !ok {
+		m.workflowsMu.Unlock()
+		return fmt.Errorf("workflow %s not found", id)
+	}
+	if wf.finished() {
+		m.workflowsMu.Unlock()
+		return fmt.Errorf("workflow %s is already %s", id, wf.State)
+	}
+
+	var running []uuid.UUID
+	for _, s := range wf.Steps {
+		switch s.State {
+		case StepRunning:
+			running = append(running, s.TaskID)
+		case StepWaiting:
+		default:
+			continue
+		}
+		s.State = StepCancelled
+		s.Message = "workflow cancelled"
+	}
+	wf.State = WorkflowCancelled
+	wf.FinishTime = time.Now()
+	m.workflowsMu.Unlock()
+
+	// the tasks are stopped without the lock, their transitions come back
+	// through onTaskTransition, which ignores steps that are no longer running
+	for _, taskID := range running {
+		result, err := m.TaskDb.Get(taskID.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		switch t.State {
+		case task.Completed, task.Failed, task.Cancelled:
+		default:
+			if err := m.StopTask(t); err != nil {
+				log.Printf("Error stopping task %v of workflow %v: %v\n", t.ID, id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isWorkflowTask reports whether the task runs a workflow step, whose retries
+// are up to the workflow rather than the health checks.
+func (m *Manager) isWorkflowTask(id uuid.UUID) bool {
+	m.workflowsMu.Lock()
+	defer m.workflowsMu.Unlock()
+	_, ok := m.workflowTasks[id]
+	return ok
+}
+
+// onTaskTransition is the state machine hook moving workflows forward when the
+// task of one of their steps finishes.
+func (m *Manager) onTaskTransition(t *task.Task, tr task.Transition) {
+	switch tr.To {
+	case task.Completed, task.Failed, task.Cancelled:
+	default:
+		return
+	}
+
+	m.workflowsMu.Lock()
+	defer m.workflowsMu.Unlock()
+	id, ok := m.workflowTasks[t.ID]
+	if !ok {
+		return
+	}
+	wf := m.Workflows[id]
+	s := wf.stepForTask(t.ID)
+	if wf.finished() || s == nil || s.State != StepRunning {
+		return
+	}
+
+	switch {
+	case tr.To == task.Completed && tr.Reason != task.ReasonStopped:
+		s.State = StepCompleted
+		s.Message = ""
+	case tr.To == task.Completed || tr.To == task.Cancelled:
+		s.State = StepCancelled
+		s.Message = fmt.Sprintf("task %s was stopped", t.ID)
+	case tr.Reason == task.ReasonRejected || tr.Reason == task.ReasonInvalidSpec:
+		// running the same spec again would be rejected again
+		s.State = StepFailed
+		s.Message = tr.Message
+	case s.Attempts <= s.MaxRetries:
+		log.Printf("Step %s of workflow %s failed (%s), retrying\n", s.Name, wf.Name, tr.Reason)
+		m.submitStep(wf, s)
+	default:
+		s.State = StepFailed
+		s.Message = fmt.Sprintf("failed after %d attempts: %s %s", s.Attempts, tr.Reason, tr.Message)
+	}
+	m.advanceWorkflow(wf)
+}
+
+// advanceWorkflow starts the steps whose upstream steps have all completed,
+// skips the ones that can no longer run and updates the workflow's state. The
+// steps are in dependency order, so one pass is enough. It must be called with
+// workflowsMu held.
+func (m *Manager) advanceWorkflow(wf *Workflow) {
+	for _, s := range wf.Steps {
+		if s.State != StepWaiting {
+			continue
+		}
+
+		ready := true
+		var blocking string
+		for _, dep := range s.DependsOn {
+			switch wf.step(dep).State {
+			case StepCompleted:
+			case StepFailed, StepSkipped, StepCancelled:
+				blocking = dep
+			default:
+				ready = false
+			}
+		}
+		if blocking != "" {
+			s.State = StepSkipped
+			s.Message = fmt.Sprintf("upstream step %s did not complete", blocking)
+			continue
+		}
+		if ready {
+			m.submitStep(wf, s)
+		}
+	}
+
+	state := WorkflowCompleted
+	for _, s := range wf.Steps {
+		switch s.State {
+		case StepWaiting, StepRunning:
+			return
+		case StepFailed, StepSkipped, StepCancelled:
+			state = WorkflowFailed
+		}
+	}
+	wf.State = state
+	wf.FinishTime = time.Now()
+	log.Printf("Workflow %s finished: %s\n", wf.Name, wf.State)
+}
+
+// submitStep adds a new task for the step to the Pending queue. Every attempt
+// gets its own task, so the failed ones stay listed.
+func (m *Manager) submitStep(wf *Workflow, s *WorkflowStep) {
+	t := s.Task
+	t.ID = uuid.New()
+	t.State = task.Pending
+	t.Transitions = nil
+	if t.Name == "" {
+		t.Name = fmt.Sprintf("%s-%s", wf.Name, s.Name)
+	}
+
+	s.TaskID = t.ID
+	s.Attempts++
+	s.State = StepRunning
+	s.Message = ""
+	m.workflowTasks[t.ID] = wf.ID
+
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task:      t,
+	})
+	log.Printf("Submitted task %v for step %s of workflow %s (attempt %d)\n", t.ID, s.Name, wf.Name, s.Attempts)
+}
@@ -0,0 +1,186 @@
+package manager
+
+import (
+	"cube/task"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowValidate(t *testing.T) {
+	step := func(name string, deps ...string) *WorkflowStep {
+		return &WorkflowStep{Name: name, DependsOn: deps}
+	}
+
+	tests := []struct {
+		name  string
+		steps []*WorkflowStep
+		order []string
+		err   string
+	}{
+		{
+			name:  "diamond",
+			steps: []*WorkflowStep{step("report", "left", "right"), step("left", "fetch"), step("right", "fetch"), step("fetch")},
+			order: []string{"fetch", "left", "right", "report"},
+		},
+		{
+			name:  "independent steps",
+			steps: []*WorkflowStep{step("a"), step("b")},
+			order: []string{"a", "b"},
+		},
+		{name: "no steps", err: "has no steps"},
+		{name: "unnamed step", steps: []*WorkflowStep{step("")}, err: "without a name"},
+		{name: "duplicate step", steps: []*WorkflowStep{step("a"), step("a")}, err: "more than one step named a"},
+		{name: "unknown dependency", steps: []*WorkflowStep{step("a", "b")}, err: "unknown step b"},
+		{name: "self dependency", steps: []*WorkflowStep{step("a", "a")}, err: "dependency cycle"},
+		{name: "cycle", steps: []*WorkflowStep{step("a", "c"), step("b", "a"), step("c", "b")}, err: "dependency cycle"},
+		{name: "cycle below a root", steps: []*WorkflowStep{step("root"), step("a", "root", "b"), step("b", "a")}, err: "dependency cycle"},
+		{
+			name:  "service step",
+			steps: []*WorkflowStep{{Name: "a", Task: task.Task{Kind: task.KindService}}},
+			err:   "must be batch tasks",
+		},
+		{name: "negative retries", steps: []*WorkflowStep{{Name: "a", MaxRetries: -1}}, err: "negative MaxRetries"},
+	}
+	for _, tt := range tests {
+		wf := &Workflow{Name: tt.name, Steps: tt.steps}
+		err := wf.Validate()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var order []string
+		for _, s := range wf.Steps {
+			order = append(order, s.Name)
+		}
+		if strings.Join(order, ",") != strings.Join(tt.order, ",") {
+			t.Errorf("%s: steps in order %v, want %v", tt.name, order, tt.order)
+		}
+	}
+}
+
+func TestWorkflowRunsStepsAfterTheirDependencies(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	wf := &Workflow{
+		Name: "etl",
+		Steps: []*WorkflowStep{
+			{Name: "fetch"},
+			{Name: "left", DependsOn: []string{"fetch"}},
+			{Name: "right", DependsOn: []string{"fetch"}},
+			{Name: "report", DependsOn: []string{"left", "right"}},
+		},
+	}
+	if err := m.AddWorkflow(wf); err != nil {
+		t.Fatal(err)
+	}
+	wantSteps(t, m, wf, map[string]StepState{"fetch": StepRunning, "left": StepWaiting, "right": StepWaiting, "report": StepWaiting})
+
+	finishStep(t, m, wf, "fetch", task.Completed, task.ReasonExited)
+	wantSteps(t, m, wf, map[string]StepState{"fetch": StepCompleted, "left": StepRunning, "right": StepRunning, "report": StepWaiting})
+
+	finishStep(t, m, wf, "left", task.Completed, task.ReasonExited)
+	wantSteps(t, m, wf, map[string]StepState{"left": StepCompleted, "right": StepRunning, "report": StepWaiting})
+
+	finishStep(t, m, wf, "right", task.Completed, task.ReasonExited)
+	wantSteps(t, m, wf, map[string]StepState{"right": StepCompleted, "report": StepRunning})
+
+	finishStep(t, m, wf, "report", task.Completed, task.ReasonExited)
+	got, _ := m.GetWorkflow(wf.ID)
+	if got.State != WorkflowCompleted {
+		t.Errorf("workflow is %s, want %s", got.State, WorkflowCompleted)
+	}
+}
+
+func TestWorkflowRetriesThenSkipsDownstream(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	wf := &Workflow{
+		Name: "etl",
+		Steps: []*WorkflowStep{
+			{Name: "fetch", MaxRetries: 1},
+			{Name: "other"},
+			{Name: "load", DependsOn: []string{"fetch"}},
+			{Name: "report", DependsOn: []string{"load", "other"}},
+		},
+	}
+	if err := m.AddWorkflow(wf); err != nil {
+		t.Fatal(err)
+	}
+
+	first := stepOf(t, m, wf, "fetch").TaskID
+	finishStep(t, m, wf, "fetch", task.Failed, task.ReasonExited)
+	s := stepOf(t, m, wf, "fetch")
+	if s.State != StepRunning || s.Attempts != 2 || s.TaskID == first {
+		t.Fatalf("after the first failure the step is %s with %d attempts on task %v, want a new running attempt", s.State, s.Attempts, s.TaskID)
+	}
+
+	finishStep(t, m, wf, "fetch", task.Failed, task.ReasonExited)
+	wantSteps(t, m, wf, map[string]StepState{"fetch": StepFailed, "load": StepSkipped, "report": StepSkipped, "other": StepRunning})
+	got, _ := m.GetWorkflow(wf.ID)
+	if got.State != WorkflowRunning {
+		t.Fatalf("workflow is %s while a branch still runs, want %s", got.State, WorkflowRunning)
+	}
+
+	finishStep(t, m, wf, "other", task.Completed, task.ReasonExited)
+	got, _ = m.GetWorkflow(wf.ID)
+	if got.State != WorkflowFailed {
+		t.Errorf("workflow is %s, want %s", got.State, WorkflowFailed)
+	}
+}
+
+func TestWorkflowDoesNotRetryRejectedStep(t *testing.T) {
+	m := New([]string{"w1:1"}, "roundrobin", "memory")
+	wf := &Workflow{Name: "etl", Steps: []*WorkflowStep{{Name: "fetch", MaxRetries: 3}}}
+	if err := m.AddWorkflow(wf); err != nil {
+		t.Fatal(err)
+	}
+
+	finishStep(t, m, wf, "fetch", task.Failed, task.ReasonInvalidSpec)
+	s := stepOf(t, m, wf, "fetch")
+	if s.State != StepFailed || s.Attempts != 1 {
+		t.Errorf("step is %s after %d attempts, want failed after 1", s.State, s.Attempts)
+	}
+}
+
+func stepOf(t *testing.T, m *Manager, wf *Workflow, name string) *WorkflowStep {
+	t.Helper()
+	got, ok := m.GetWorkflow(wf.ID)
+	if !ok {
+		t.Fatalf("workflow %s not found", wf.ID)
+	}
+	s := got.step(name)
+	if s == nil {
+		t.Fatalf("workflow has no step %s", name)
+	}
+	return s
+}
+
+func wantSteps(t *testing.T, m *Manager, wf *Workflow, want map[string]StepState) {
+	t.Helper()
+	for name, state := range want {
+		if s := stepOf(t, m, wf, name); s.State != state {
+			t.Errorf("step %s is %s, want %s", name, s.State, state)
+		}
+	}
+}
+
+// finishStep plays the worker: it moves the task of the step's latest attempt
+// through Scheduled and Running to the given state.
+func finishStep(t *testing.T, m *Manager, wf *Workflow, name string, state task.State, reason string) {
+	t.Helper()
+	id := stepOf(t, m, wf, name).TaskID
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := result.(*task.Task)
+	for _, next := range []task.State{task.Scheduled, task.Running, state} {
+		if err := m.StateMachine.Transition(tk, next, reason, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -2,13 +2,16 @@ package node
 
 import (
 	"cube/stats"
+	"cube/task"
 	"cube/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"io"
 	"log"
 	"net/http"
+	"sync"
 )
 
 type Node struct {
@@ -23,6 +26,19 @@ type Node struct {
 	Stats           stats.Stats
 	Role            string
 	TaskCount       int
+	// capabilities are the resource limits each of the worker's drivers can
+	// enforce; nil until they have been fetched from the worker. The map is
+	// replaced, never changed, under capabilitiesMu.
+	capabilities   map[string]task.Capabilities
+	capabilitiesMu sync.RWMutex
+	// MinPort and MaxPort bound the host ports the manager allocates to tasks
+	// on the node, DefaultMinPort and DefaultMaxPort when 0; PortsAllocated
+	// counts the ports held by tasks.
+	MinPort        int
+	MaxPort        int
+	PortsAllocated int
+	ports          map[int]uuid.UUID
+	portsMu        sync.Mutex
 }
 
 func NewNode(name string, api string, role string) *Node {
@@ -109,3 +125,90 @@ func (n *Node) GetCpuUsage() (float64, error) {
 
 	return u.Percentage, nil
 }
+
+func (n *Node) GetCapabilities() (map[string]task.Capabilities, error) {
+	url := fmt.Sprintf("%s/capabilities", n.Api)
+	resp, err := http.Get(url)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to connect to %v: %v", n.Api, err)
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg := fmt.Sprintf("Error retrieving capabilities from %v: %v", n.Api, resp.StatusCode)
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+
+	var caps map[string]task.Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		msg := fmt.Sprintf("error decoding message while getting capabilities for node %s", n.Name)
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+
+	n.capabilitiesMu.Lock()
+	n.capabilities = caps
+	n.capabilitiesMu.Unlock()
+	return caps, nil
+}
+
+// Capabilities returns the capabilities last fetched from the worker, nil when
+// they have not been fetched yet. The map must not be changed.
+func (n *Node) Capabilities() map[string]task.Capabilities {
+	n.capabilitiesMu.RLock()
+	defer n.capabilitiesMu.RUnlock()
+	return n.capabilities
+}
+
+// Info is what a worker tells about itself. Address is the IP address its
+// tasks are reached at, empty when the worker does not advertise one.
+type Info struct {
+	Name    string
+	Address string
+}
+
+// GetInfo asks the worker for its name and advertised address.
+func (n *Node) GetInfo() (*Info, error) {
+	url := fmt.Sprintf("%s/info", n.Api)
+	resp, err := http.Get(url)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to connect to %v: %v", n.Api, err)
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg := fmt.Sprintf("Error retrieving info from %v: %v", n.Api, resp.StatusCode)
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+
+	var info Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		msg := fmt.Sprintf("error decoding message while getting info for node %s", n.Name)
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+	return &info, nil
+}
+
+// CheckResources tells whether the node can enforce every limit the task asks
+// for. A node whose capabilities are not known yet is given the benefit of the doubt.
+func (n *Node) CheckResources(t task.Task) error {
+	all := n.Capabilities()
+	if all == nil {
+		return nil
+	}
+	caps, ok := all[t.DriverName()]
+	if !ok {
+		return fmt.Errorf("node %s has no %s driver", n.Name, t.DriverName())
+	}
+	if err := caps.Check(t); err != nil {
+		return fmt.Errorf("node %s: %v", n.Name, err)
+	}
+	return nil
+}
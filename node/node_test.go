@@ -0,0 +1,49 @@
+package node
+
+import (
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCapabilitiesRefreshedWhileChecked(t *testing.T) {
+	// run with -race: the manager refreshes the capabilities while it schedules
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]task.Capabilities{"docker": {Cpu: true, Memory: true}})
+	}))
+	defer srv.Close()
+	n := NewNode("w1", srv.URL, "worker")
+
+	if err := n.CheckResources(task.Task{Memory: 1 << 20}); err != nil {
+		t.Errorf("node without capabilities rejected the task: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := n.GetCapabilities(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			n.CheckResources(task.Task{Memory: 1 << 20})
+		}
+	}()
+	wg.Wait()
+
+	if err := n.CheckResources(task.Task{Memory: 1 << 20}); err != nil {
+		t.Errorf("task within the node's capabilities rejected: %v", err)
+	}
+	if err := n.CheckResources(task.Task{PidsLimit: 10}); err == nil {
+		t.Error("task asking for a pids limit the node cannot enforce accepted")
+	}
+}
@@ -0,0 +1,213 @@
+package node
+
+import (
+	"cube/task"
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+)
+
+// The range host ports are allocated from when none is configured.
+const (
+	DefaultMinPort = 30000
+	DefaultMaxPort = 32767
+)
+
+// ParsePortRange parses a range of host ports written as "min-max".
+func ParsePortRange(s string) (int, int, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("port range %q is not of the form min-max", s)
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return min, max, nil
+}
+
+// portRequests splits the host ports the task needs into the ones it fixes, in
+// its PortBindings or by running in host network mode, and the container ports
+// it publishes on a host port of the manager's choosing.
+func portRequests(t task.Task) ([]int, []nat.Port) {
+	var fixed []int
+	var dynamic []nat.Port
+	if t.NetworkMode.IsHost() {
+		for p := range t.ExposedPort {
+			fixed = append(fixed, p.Int())
+		}
+		return fixed, nil
+	}
+	if t.NetworkMode.IsContainer() {
+		return nil, nil
+	}
+	for _, bindings := range t.PortBindings {
+		for _, b := range bindings {
+			if port, err := strconv.Atoi(b.HostPort); err == nil && port != 0 {
+				fixed = append(fixed, port)
+			}
+		}
+	}
+	for p := range t.ExposedPort {
+		if hasHostPort(t.PortBindings[p]) {
+			continue
+		}
+		dynamic = append(dynamic, p)
+	}
+	return fixed, dynamic
+}
+
+func hasHostPort(bindings []nat.PortBinding) bool {
+	for _, b := range bindings {
+		if port, err := strconv.Atoi(b.HostPort); err == nil && port != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// usable tells whether the host port is free or already held by the task.
+func (n *Node) usable(port int, id uuid.UUID) bool {
+	owner, ok := n.ports[port]
+	return !ok || owner == id
+}
+
+// CheckPorts tells whether the node can give the task the host ports it needs:
+// its fixed ports are not held by another task and enough ports of the range are free.
+func (n *Node) CheckPorts(t task.Task) error {
+	n.portsMu.Lock()
+	defer n.portsMu.Unlock()
+	fixed, dynamic := portRequests(t)
+	for _, p := range fixed {
+		if !n.usable(p, t.ID) {
+			return fmt.Errorf("node %s: host port %d is in use", n.Name, p)
+		}
+	}
+	min, max := n.portRange()
+	free := 0
+	for p := min; p <= max && free < len(dynamic); p++ {
+		if n.usable(p, t.ID) {
+			free++
+		}
+	}
+	if free < len(dynamic) {
+		return fmt.Errorf("node %s has no free host ports left", n.Name)
+	}
+	return nil
+}
+
+// AllocatePorts holds the host ports the task needs on the node and returns the
+// bindings of its published ports. A port the task was given before, recorded in
+// its HostPorts, is given again if it is still free, so a restarted task keeps its ports.
+func (n *Node) AllocatePorts(t task.Task) (nat.PortMap, error) {
+	n.portsMu.Lock()
+	defer n.portsMu.Unlock()
+	if n.ports == nil {
+		n.ports = make(map[int]uuid.UUID)
+	}
+	fixed, dynamic := portRequests(t)
+	for _, p := range fixed {
+		if !n.usable(p, t.ID) {
+			return nil, fmt.Errorf("node %s: host port %d is in use", n.Name, p)
+		}
+	}
+
+	held := make(map[int]bool)
+	for _, p := range fixed {
+		held[p] = true
+	}
+	bindings := make(nat.PortMap)
+	for p, b := range t.PortBindings {
+		bindings[p] = b
+	}
+	min, max := n.portRange()
+	next := min
+	for _, p := range dynamic {
+		port := 0
+		for _, b := range t.HostPorts[p] {
+			prev, err := strconv.Atoi(b.HostPort)
+			if err == nil && prev >= min && prev <= max && !held[prev] && n.usable(prev, t.ID) {
+				port = prev
+				break
+			}
+		}
+		for ; port == 0 && next <= max; next++ {
+			if !held[next] && n.usable(next, t.ID) {
+				port = next
+			}
+		}
+		if port == 0 {
+			return nil, fmt.Errorf("node %s has no free host ports left", n.Name)
+		}
+		held[port] = true
+		bindings[p] = []nat.PortBinding{{HostPort: strconv.Itoa(port)}}
+	}
+
+	n.releasePorts(t.ID)
+	for p := range held {
+		n.ports[p] = t.ID
+	}
+	n.PortsAllocated = len(n.ports)
+	return bindings, nil
+}
+
+// HoldPorts records the host ports the task was given, in its HostPorts, and the
+// ones it fixes as held by it, e.g. when a restarted manager learns again where
+// its tasks run. A port held by another task is left to that task.
+func (n *Node) HoldPorts(t task.Task) error {
+	n.portsMu.Lock()
+	defer n.portsMu.Unlock()
+	if n.ports == nil {
+		n.ports = make(map[int]uuid.UUID)
+	}
+	ports, _ := portRequests(t)
+	for _, bindings := range t.HostPorts {
+		for _, b := range bindings {
+			if port, err := strconv.Atoi(b.HostPort); err == nil && port != 0 {
+				ports = append(ports, port)
+			}
+		}
+	}
+	var err error
+	for _, p := range ports {
+		if !n.usable(p, t.ID) {
+			err = fmt.Errorf("node %s: host port %d is held by task %s", n.Name, p, n.ports[p])
+			continue
+		}
+		n.ports[p] = t.ID
+	}
+	n.PortsAllocated = len(n.ports)
+	return err
+}
+
+// ReleasePorts frees the host ports held by the task.
+func (n *Node) ReleasePorts(id uuid.UUID) {
+	n.portsMu.Lock()
+	defer n.portsMu.Unlock()
+	n.releasePorts(id)
+	n.PortsAllocated = len(n.ports)
+}
+
+func (n *Node) releasePorts(id uuid.UUID) {
+	for p, owner := range n.ports {
+		if owner == id {
+			delete(n.ports, p)
+		}
+	}
+}
+
+func (n *Node) portRange() (int, int) {
+	if n.MinPort == 0 || n.MaxPort == 0 {
+		return DefaultMinPort, DefaultMaxPort
+	}
+	return n.MinPort, n.MaxPort
+}
@@ -0,0 +1,130 @@
+package node
+
+import (
+	"cube/task"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max int
+		err      bool
+	}{
+		{in: "30000-32767", min: 30000, max: 32767},
+		{in: " 80 - 81 ", min: 80, max: 81},
+		{in: "8080", err: true},
+		{in: "90-80", err: true},
+		{in: "0-80", err: true},
+		{in: "80-70000", err: true},
+		{in: "a-b", err: true},
+	}
+	for _, tt := range tests {
+		min, max, err := ParsePortRange(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParsePortRange(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !tt.err && (min != tt.min || max != tt.max) {
+			t.Errorf("ParsePortRange(%q) = %d, %d, want %d, %d", tt.in, min, max, tt.min, tt.max)
+		}
+	}
+}
+
+func webTask(bindings nat.PortMap) task.Task {
+	return task.Task{
+		ID:           uuid.New(),
+		ExposedPort:  nat.PortSet{"80/tcp": {}, "443/tcp": {}},
+		PortBindings: bindings,
+	}
+}
+
+func hostPort(t *testing.T, m nat.PortMap, p nat.Port) string {
+	t.Helper()
+	if len(m[p]) != 1 {
+		t.Fatalf("port %s has bindings %v, want one", p, m[p])
+	}
+	return m[p][0].HostPort
+}
+
+func TestAllocatePorts(t *testing.T) {
+	n := NewNode("w1", "http://w1", "worker")
+	n.MinPort, n.MaxPort = 31000, 31002
+
+	fixed := webTask(nat.PortMap{"443/tcp": {{HostPort: "8443"}}})
+	got, err := n.AllocatePorts(fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := hostPort(t, got, "443/tcp"); p != "8443" {
+		t.Errorf("fixed port got host port %s, want 8443", p)
+	}
+	if p := hostPort(t, got, "80/tcp"); p != "31000" {
+		t.Errorf("dynamic port got host port %s, want 31000", p)
+	}
+
+	// another task cannot have the fixed port
+	if _, err := n.AllocatePorts(webTask(nat.PortMap{"443/tcp": {{HostPort: "8443"}}})); err == nil {
+		t.Errorf("a second task was given host port 8443")
+	}
+	if n.PortsAllocated != 2 {
+		t.Errorf("PortsAllocated = %d after a refused allocation, want 2", n.PortsAllocated)
+	}
+
+	// two dynamic ports take the rest of the range, a third task gets none
+	other := webTask(nil)
+	if _, err := n.AllocatePorts(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.CheckPorts(webTask(nil)); err == nil {
+		t.Errorf("CheckPorts accepted a task with the range exhausted")
+	}
+
+	// a restarted task keeps the host ports recorded in its HostPorts
+	fixed.HostPorts = got
+	again, err := n.AllocatePorts(fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := hostPort(t, again, "80/tcp"); p != "31000" {
+		t.Errorf("restarted task got host port %s, want its previous 31000", p)
+	}
+
+	n.ReleasePorts(other.ID)
+	if n.PortsAllocated != 2 {
+		t.Errorf("PortsAllocated = %d after a release, want 2", n.PortsAllocated)
+	}
+	if err := n.CheckPorts(webTask(nil)); err != nil {
+		t.Errorf("CheckPorts after a release: %v", err)
+	}
+}
+
+func TestHoldPorts(t *testing.T) {
+	n := NewNode("w1", "http://w1", "worker")
+	a := webTask(nil)
+	a.HostPorts = nat.PortMap{"80/tcp": {{HostPort: "30000"}}, "443/tcp": {{HostPort: "30001"}}}
+	if err := n.HoldPorts(a); err != nil {
+		t.Fatal(err)
+	}
+	if n.PortsAllocated != 2 {
+		t.Errorf("PortsAllocated = %d, want 2", n.PortsAllocated)
+	}
+
+	b := webTask(nil)
+	b.HostPorts = nat.PortMap{"80/tcp": {{HostPort: "30001"}}, "443/tcp": {{HostPort: "30002"}}}
+	if err := n.HoldPorts(b); err == nil {
+		t.Errorf("HoldPorts gave port 30001 to a second task")
+	}
+	got, err := n.AllocatePorts(webTask(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, bindings := range got {
+		if hp := bindings[0].HostPort; hp == "30000" || hp == "30001" || hp == "30002" {
+			t.Errorf("port %s was given held host port %s", p, hp)
+		}
+	}
+}
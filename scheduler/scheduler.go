@@ -19,8 +19,15 @@ type RoundRobin struct {
 	LastWorker int
 }
 
-func (r *RoundRobin) SelectCandidateNodes(task task.Task, nodes []*node.Node) []*node.Node {
-	return nodes
+func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for n := range nodes {
+		if nodes[n].CheckResources(t) == nil && nodes[n].CheckPorts(t) == nil {
+			candidates = append(candidates, nodes[n])
+		}
+	}
+
+	return candidates
 }
 
 func (r *RoundRobin) Score(task task.Task, nodes []*node.Node) map[string]float64 {
@@ -73,7 +80,8 @@ type Epvm struct {
 func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
 	var candidates []*node.Node
 	for n := range nodes {
-		if checkDisk(t, nodes[n].Disk-nodes[n].DiskAllocated) {
+		if checkDisk(t, nodes[n].Disk-nodes[n].DiskAllocated) && nodes[n].CheckResources(t) == nil &&
+			nodes[n].CheckPorts(t) == nil {
 			candidates = append(candidates, nodes[n])
 		}
 	}
@@ -82,7 +90,7 @@ func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Nod
 }
 
 func checkDisk(t task.Task, diskAvailable int64) bool {
-	return t.Disk <= diskAvailable
+	return t.Resources().Disk <= diskAvailable
 }
 
 const (
@@ -111,7 +119,7 @@ func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 		memoryAllocated := float64(n.Stats.MemUsedKb()) + float64(n.MemoryAllocated)
 		memoryPercentAllocated := memoryAllocated / float64(n.Memory)
 
-		newMemPercent := calculateLoad(memoryAllocated+float64(t.Memory/1000), float64(n.Memory))
+		newMemPercent := calculateLoad(memoryAllocated+float64(t.Resources().Memory/1000), float64(n.Memory))
 
 		memCost :=
 			math.Pow(LIEB, newMemPercent) +
@@ -1,12 +1,12 @@
 package store
 
 import (
-	"cube/task"
 	"encoding/json"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
 	"os"
+	"sync"
 )
 
 type Store interface {
@@ -14,325 +14,178 @@ type Store interface {
 	Get(key string) (interface{}, error)
 	List() (interface{}, error)
 	Count() (int, error)
+	Delete(key string) error
 }
 
-/*
-	In order to keep things simple, we use two in-memory store for tasks and taskEvents separately
-*/
-
-type InMemoryTaskStore struct {
-	Db map[string]*task.Task
+// InMemoryStore keeps values of type *T in memory by key. Kind names the values
+// in errors, e.g. "service".
+type InMemoryStore[T any] struct {
+	Db   map[string]*T
+	Kind string
+	mu   sync.RWMutex
 }
 
-func NewInMemoryTaskStore() *InMemoryTaskStore {
-	return &InMemoryTaskStore{
-		Db: make(map[string]*task.Task),
+func NewInMemoryStore[T any](kind string) *InMemoryStore[T] {
+	return &InMemoryStore[T]{
+		Db:   make(map[string]*T),
+		Kind: kind,
 	}
 }
 
-func (i *InMemoryTaskStore) Put(key string, value interface{}) error {
-	t, ok := value.(*task.Task)
+func (i *InMemoryStore[T]) Put(key string, value interface{}) error {
+	v, ok := value.(*T)
 	if !ok {
-		return fmt.Errorf("value %v is not a *task.Task", value)
+		return fmt.Errorf("value %v is not a %T", value, v)
 	}
-	i.Db[key] = t
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.Db[key] = v
 	return nil
 }
 
-func (i *InMemoryTaskStore) Get(key string) (interface{}, error) {
-	t, ok := i.Db[key]
+func (i *InMemoryStore[T]) Get(key string) (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	v, ok := i.Db[key]
 	if !ok {
-		return nil, fmt.Errorf("task with key %s not exists", key)
+		return nil, fmt.Errorf("%s with key %s not exists", i.Kind, key)
 	}
-	return t, nil
+	return v, nil
 }
 
-func (i *InMemoryTaskStore) List() (interface{}, error) {
-	var tasks []*task.Task
-	for _, t := range i.Db {
-		tasks = append(tasks, t)
+func (i *InMemoryStore[T]) List() (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var values []*T
+	for _, v := range i.Db {
+		values = append(values, v)
 	}
-	return tasks, nil
+	return values, nil
 }
 
-func (i *InMemoryTaskStore) Count() (int, error) {
+func (i *InMemoryStore[T]) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.Db), nil
 }
 
-type InMemoryTaskEventStore struct {
-	Db map[string]*task.TaskEvent
-}
-
-func NewInMemoryTaskEventStore() *InMemoryTaskEventStore {
-	return &InMemoryTaskEventStore{
-		Db: make(map[string]*task.TaskEvent),
-	}
-}
-
-func (i *InMemoryTaskEventStore) Put(key string, value interface{}) error {
-	te, ok := value.(*task.TaskEvent)
-	if !ok {
-		return fmt.Errorf("value %v is not a *task.TaskEvent", value)
-	}
-	i.Db[key] = te
+func (i *InMemoryStore[T]) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.Db, key)
 	return nil
 }
 
-func (i *InMemoryTaskEventStore) Get(key string) (interface{}, error) {
-	te, ok := i.Db[key]
-	if !ok {
-		return nil, fmt.Errorf("task event with key %s not exists", key)
-	}
-	return te, nil
-}
-
-func (i *InMemoryTaskEventStore) List() (interface{}, error) {
-	var events []*task.TaskEvent
-	for _, te := range i.Db {
-		events = append(events, te)
-	}
-	return events, nil
-}
-
-func (i *InMemoryTaskEventStore) Count() (int, error) {
-	return len(i.Db), nil
-}
-
-type TaskStore struct {
+// JSONStore persists values of type *T as JSON in a bolt bucket. Kind names the
+// values in errors, e.g. "service".
+type JSONStore[T any] struct {
 	Db       *bolt.DB
 	DbFile   string
 	FileMode os.FileMode
 	Bucket   string
+	Kind     string
 }
 
-func NewTaskStore(file string, mode os.FileMode, bucket string) (*TaskStore, error) {
+func NewJSONStore[T any](file string, mode os.FileMode, bucket string, kind string) (*JSONStore[T], error) {
 	db, err := bolt.Open(file, mode, nil)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open %v", file)
 	}
-	t := TaskStore{
+	s := JSONStore[T]{
 		DbFile:   file,
 		FileMode: mode,
 		Db:       db,
 		Bucket:   bucket,
+		Kind:     kind,
 	}
 
-	err = t.CreateBucket()
+	err = s.CreateBucket()
 	if err != nil {
 		log.Printf("bucket already exists, will use it instead of creating new one")
 	}
 
-	return &t, nil
-
+	return &s, nil
 }
 
-func (t *TaskStore) Close() {
-	t.Db.Close()
+func (s *JSONStore[T]) Close() {
+	s.Db.Close()
 }
 
-func (t *TaskStore) CreateBucket() error {
-	return t.Db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte(t.Bucket))
+func (s *JSONStore[T]) CreateBucket() error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(s.Bucket))
 		if err != nil {
-			return fmt.Errorf("create bucket %s: %s", t.Bucket, err)
+			return fmt.Errorf("create bucket %s: %s", s.Bucket, err)
 		}
 		return nil
 	})
-
 }
 
-func (t *TaskStore) Count() (int, error) {
-	taskCount := 0
-	err := t.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("tasks"))
-		b.ForEach(func(_, _ []byte) error {
-			taskCount++
-			return nil
-		})
+func (s *JSONStore[T]) Count() (int, error) {
+	count := 0
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket([]byte(s.Bucket)).Stats().KeyN
 		return nil
 	})
 	if err != nil {
 		return -1, err
 	}
-
-	return taskCount, nil
-
+	return count, nil
 }
 
-func (t *TaskStore) Put(key string, value interface{}) error {
-	return t.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(t.Bucket))
-
-		buf, err := json.Marshal(value.(*task.Task))
-		if err != nil {
-			return err
-		}
+func (s *JSONStore[T]) Put(key string, value interface{}) error {
+	v, ok := value.(*T)
+	if !ok {
+		return fmt.Errorf("value %v is not a %T", value, v)
+	}
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
 
-		err = b.Put([]byte(key), buf)
+		buf, err := json.Marshal(v)
 		if err != nil {
 			return err
 		}
-		return nil
+		return b.Put([]byte(key), buf)
 	})
 }
 
-func (t *TaskStore) Get(key string) (interface{}, error) {
-	var tsk task.Task
-	err := t.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(t.Bucket))
-		t := b.Get([]byte(key))
-		if t == nil {
-			return fmt.Errorf("task %v not found", key)
+func (s *JSONStore[T]) Get(key string) (interface{}, error) {
+	var v T
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		buf := b.Get([]byte(key))
+		if buf == nil {
+			return fmt.Errorf("%s %v not found", s.Kind, key)
 		}
-		err := json.Unmarshal(t, &tsk)
-		if err != nil {
-			return err
-		}
-		return nil
+		return json.Unmarshal(buf, &v)
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &tsk, nil
-
+	return &v, nil
 }
 
-func (t *TaskStore) List() (interface{}, error) {
-	var tasks []*task.Task
-	err := t.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(t.Bucket))
-		b.ForEach(func(k, v []byte) error {
-			var tsk task.Task
-			err := json.Unmarshal(v, &tsk)
-			if err != nil {
+func (s *JSONStore[T]) List() (interface{}, error) {
+	var values []*T
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		return b.ForEach(func(k, buf []byte) error {
+			var v T
+			if err := json.Unmarshal(buf, &v); err != nil {
 				return err
 			}
-			tasks = append(tasks, &tsk)
-			return nil
-		})
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return tasks, nil
-}
-
-type EventStore struct {
-	DbFile   string
-	FileMode os.FileMode
-	Db       *bolt.DB
-	Bucket   string
-}
-
-func NewEventStore(file string, mode os.FileMode, bucket string) (*EventStore, error) {
-	db, err := bolt.Open(file, mode, nil)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open %v", file)
-	}
-	e := EventStore{
-		DbFile:   file,
-		FileMode: mode,
-		Db:       db,
-		Bucket:   bucket,
-	}
-
-	err = e.CreateBucket()
-	if err != nil {
-		log.Printf("bucket already exists, will use it instead of creating new one")
-	}
-
-	return &e, nil
-}
-
-func (e *EventStore) Close() {
-	e.Db.Close()
-}
-
-func (e *EventStore) CreateBucket() error {
-	return e.Db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte(e.Bucket))
-		if err != nil {
-			return fmt.Errorf("create bucket %s: %s", e.Bucket, err)
-		}
-		return nil
-	})
-}
-func (e *EventStore) Count() (int, error) {
-	eventCount := 0
-	err := e.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(e.Bucket))
-		b.ForEach(func(k, v []byte) error {
-			eventCount++
+			values = append(values, &v)
 			return nil
 		})
-		return nil
 	})
-	if err != nil {
-		return -1, err
-	}
-
-	return eventCount, nil
-}
-
-func (e *EventStore) Put(key string, value interface{}) error {
-	return e.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(e.Bucket))
-
-		buf, err := json.Marshal(value.(*task.TaskEvent))
-		if err != nil {
-			return err
-		}
-
-		err = b.Put([]byte(key), buf)
-		if err != nil {
-			log.Printf("unable to save item %s", key)
-			return err
-		}
-		return nil
-	})
-}
-
-func (e *EventStore) Get(key string) (interface{}, error) {
-	var event task.TaskEvent
-	err := e.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(e.Bucket))
-		t := b.Get([]byte(key))
-		if t == nil {
-			return fmt.Errorf("event %v not found", key)
-		}
-		err := json.Unmarshal(t, &event)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-
 	if err != nil {
 		return nil, err
 	}
-	return &event, nil
+	return values, nil
 }
 
-func (e *EventStore) List() (interface{}, error) {
-	var events []*task.TaskEvent
-	err := e.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(e.Bucket))
-		b.ForEach(func(k, v []byte) error {
-			var event task.TaskEvent
-			err := json.Unmarshal(v, &event)
-			if err != nil {
-				return err
-			}
-			events = append(events, &event)
-			return nil
-		})
-		return nil
+func (s *JSONStore[T]) Delete(key string) error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(s.Bucket)).Delete([]byte(key))
 	})
-	if err != nil {
-		return nil, err
-	}
-
-	return events, nil
 }
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"cube/task"
+	"path/filepath"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	if err := s.Put("echo", &task.Service{Name: "echo", Replicas: 2}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put("web", &task.Service{Name: "web"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put("bad", &task.Route{Name: "bad"}); err == nil {
+		t.Errorf("Put of a value of another type succeeded")
+	}
+
+	result, err := s.Get("echo")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := result.(*task.Service); got.Replicas != 2 {
+		t.Errorf("Get returned %+v", got)
+	}
+	if _, err := s.Get("missing"); err == nil {
+		t.Errorf("Get of a missing key succeeded")
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if n := len(list.([]*task.Service)); n != 2 {
+		t.Errorf("List returned %d services, want 2", n)
+	}
+	if n, _ := s.Count(); n != 2 {
+		t.Errorf("Count = %d, want 2", n)
+	}
+
+	if err := s.Delete("echo"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if n, _ := s.Count(); n != 1 {
+		t.Errorf("Count after Delete = %d, want 1", n)
+	}
+}
+
+func TestInMemoryStore(t *testing.T) {
+	testStore(t, NewInMemoryStore[task.Service]("service"))
+}
+
+func TestJSONStore(t *testing.T) {
+	s, err := NewJSONStore[task.Service](filepath.Join(t.TempDir(), "services.db"), 0600, "services", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
+
+func TestJSONStoreReopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tasks.db")
+	s, err := NewJSONStore[task.Task](file, 0600, "tasks", "task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("web", &task.Task{Name: "web", State: task.Running}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// a worker or manager that restarts finds its tasks in the existing bucket
+	s, err = NewJSONStore[task.Task](file, 0600, "tasks", "task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	result, err := s.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(*task.Task); got.Name != "web" || got.State != task.Running {
+		t.Errorf("Get after reopening returned %+v", got)
+	}
+}
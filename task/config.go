@@ -0,0 +1,129 @@
+package task
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// maxConfigSize is the largest config the manager stores, maxConfigVersions how
+// many versions of a config it keeps.
+const (
+	maxConfigSize     = 1 << 20
+	maxConfigVersions = 10
+)
+
+/*
+  - AppConfig is a named piece of configuration, text or JSON, stored on the manager. Storing a
+    config under an existing name adds a new version; Versions keeps the latest ones, newest last.
+
+  - It is called AppConfig to tell it from Config, the configuration of a task's container
+    handed to the runtime.
+*/
+type AppConfig struct {
+	Name     string
+	Versions []ConfigVersion
+}
+
+type ConfigVersion struct {
+	Version      int
+	Data         string
+	CreationTime time.Time
+}
+
+/*
+  - ConfigRef makes a config available to a task as a read-only file at the absolute path File,
+    written by the worker when it starts the task.
+
+  - Version pins the version of the config; when it is 0 the task gets the latest version and is
+    rolled when a new version is stored, a task of a service through a rolling update of the service.
+*/
+type ConfigRef struct {
+	Name    string
+	File    string
+	Version int
+}
+
+func ValidConfigName(name string) bool {
+	return ValidSecretName(name)
+}
+
+func (r ConfigRef) Validate() error {
+	if !ValidConfigName(r.Name) {
+		return fmt.Errorf("invalid config name %q", r.Name)
+	}
+	if r.Version < 0 {
+		return fmt.Errorf("config %s has a negative version", r.Name)
+	}
+	if !path.IsAbs(r.File) || path.Clean(r.File) == "/" {
+		return fmt.Errorf("config file %q of config %s is not an absolute file path", r.File, r.Name)
+	}
+	return nil
+}
+
+// ValidateConfigs checks the task's config references. A task uses one version
+// of each config, so references to the same config have to agree on it.
+func ValidateConfigs(t Task) error {
+	versions := make(map[string]int)
+	for _, r := range t.Configs {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if v, ok := versions[r.Name]; ok && v != r.Version {
+			return fmt.Errorf("config %s is referenced with different versions", r.Name)
+		}
+		versions[r.Name] = r.Version
+	}
+	return nil
+}
+
+// Latest returns the config's newest version.
+func (c *AppConfig) Latest() ConfigVersion {
+	if len(c.Versions) == 0 {
+		return ConfigVersion{}
+	}
+	return c.Versions[len(c.Versions)-1]
+}
+
+// Version returns the given version of the config, the latest for 0.
+func (c *AppConfig) Version(v int) (ConfigVersion, error) {
+	if v == 0 && len(c.Versions) > 0 {
+		return c.Latest(), nil
+	}
+	for _, cv := range c.Versions {
+		if cv.Version == v {
+			return cv, nil
+		}
+	}
+	return ConfigVersion{}, fmt.Errorf("config %s has no version %d", c.Name, v)
+}
+
+// Add stores data as the config's next version and returns it; the oldest
+// versions are dropped beyond the ones kept.
+func (c *AppConfig) Add(data string) (ConfigVersion, error) {
+	if len(data) > maxConfigSize {
+		return ConfigVersion{}, fmt.Errorf("config %s is larger than %d bytes", c.Name, maxConfigSize)
+	}
+	cv := ConfigVersion{Version: c.Latest().Version + 1, Data: data, CreationTime: time.Now()}
+	c.Versions = append(c.Versions, cv)
+	if len(c.Versions) > maxConfigVersions {
+		c.Versions = c.Versions[len(c.Versions)-maxConfigVersions:]
+	}
+	return cv, nil
+}
+
+// ConfigOutdated returns a config the task follows the latest version of but
+// runs with an older version than latest, which maps config names to their
+// latest version. A task that has not been sent to a worker yet is not outdated.
+func ConfigOutdated(t *Task, latest map[string]int) (string, bool) {
+	for _, r := range t.Configs {
+		if r.Version != 0 {
+			continue
+		}
+		v, ok := t.ConfigVersions[r.Name]
+		if ok && latest[r.Name] > v {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
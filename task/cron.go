@@ -0,0 +1,309 @@
+package task
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Concurrency policies of a CronJob, deciding what happens when a run is due
+// while the previous one has not finished. An empty policy behaves like ConcurrencyAllow.
+const (
+	ConcurrencyAllow   = "Allow"
+	ConcurrencyForbid  = "Forbid"
+	ConcurrencyReplace = "Replace"
+)
+
+const (
+	DefaultSuccessfulRunsHistoryLimit = 3
+	DefaultFailedRunsHistoryLimit     = 1
+)
+
+/*
+  - Schedule is a standard five field cron expression (minute hour day-of-month month day-of-week)
+    or one of @yearly, @monthly, @weekly, @daily and @hourly. It is evaluated in TimeZone, an IANA
+    name such as "Europe/Berlin", UTC when empty.
+
+  - StartingDeadlineSeconds is the missed-run deadline: a run that could not be started within that
+    many seconds of its scheduled time, e.g. because the manager was down, is skipped. 0 means no deadline.
+
+  - Successful and failed runs are kept in History up to their limits, 3 and 1 when not set;
+    runs that were missed or skipped by the Forbid policy are only counted in MissedRuns.
+*/
+type CronJob struct {
+	Name                       string
+	Schedule                   string
+	TimeZone                   string
+	ConcurrencyPolicy          string
+	StartingDeadlineSeconds    int64
+	SuccessfulRunsHistoryLimit *int
+	FailedRunsHistoryLimit     *int
+	Task                       Task
+	CreationTime               time.Time
+	LastScheduleTime           time.Time
+	// Active holds the runs whose task has not finished yet.
+	Active     []CronRun
+	History    []CronRun
+	MissedRuns int
+}
+
+// CronRun is a task started by a CronJob; State and FinishTime are set once it finished.
+type CronRun struct {
+	TaskID        uuid.UUID
+	ScheduledTime time.Time
+	State         State
+	FinishTime    time.Time
+}
+
+func (c *CronJob) Validate() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, "/ ") {
+		return fmt.Errorf("cron job name %q is empty or contains a slash or a space", c.Name)
+	}
+	schedule, err := c.ParseSchedule()
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("cron schedule %q never runs", c.Schedule)
+	}
+	switch c.ConcurrencyPolicy {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return fmt.Errorf("invalid concurrency policy %q", c.ConcurrencyPolicy)
+	}
+	if c.StartingDeadlineSeconds < 0 {
+		return fmt.Errorf("StartingDeadlineSeconds must not be negative")
+	}
+	if (c.SuccessfulRunsHistoryLimit != nil && *c.SuccessfulRunsHistoryLimit < 0) ||
+		(c.FailedRunsHistoryLimit != nil && *c.FailedRunsHistoryLimit < 0) {
+		return fmt.Errorf("history limits must not be negative")
+	}
+	return ValidatePullPolicies(c.Task)
+}
+
+func (c *CronJob) ParseSchedule() (*CronSchedule, error) {
+	loc := time.UTC
+	if c.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", c.TimeZone, err)
+		}
+	}
+	return ParseCronSchedule(c.Schedule, loc)
+}
+
+// AddRun records a finished run and drops the oldest runs beyond the history limits.
+func (c *CronJob) AddRun(run CronRun) {
+	c.History = append(c.History, run)
+
+	succeeded, failed := DefaultSuccessfulRunsHistoryLimit, DefaultFailedRunsHistoryLimit
+	if c.SuccessfulRunsHistoryLimit != nil {
+		succeeded = *c.SuccessfulRunsHistoryLimit
+	}
+	if c.FailedRunsHistoryLimit != nil {
+		failed = *c.FailedRunsHistoryLimit
+	}
+
+	// walk from the newest run, keeping as many of each kind as allowed
+	var kept []CronRun
+	for i := len(c.History) - 1; i >= 0; i-- {
+		r := c.History[i]
+		if r.State == Completed {
+			if succeeded == 0 {
+				continue
+			}
+			succeeded--
+		} else {
+			if failed == 0 {
+				continue
+			}
+			failed--
+		}
+		kept = append([]CronRun{r}, kept...)
+	}
+	c.History = kept
+}
+
+// CronSchedule is a parsed cron expression, one bit per allowed value of each field.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day matches either day field when both are restricted, like cron does
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+func ParseCronSchedule(spec string, loc *time.Location) (*CronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %q must have 5 fields", spec)
+	}
+
+	s := CronSchedule{loc: loc}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	// 7 is accepted for Sunday as well
+	if s.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (a-b) and
+// steps (*/n, a-b/n) into a bit set.
+func parseCronField(field string, min int, max int, names map[string]int) (uint64, error) {
+	value := func(v string) (int, error) {
+		if n, ok := names[strings.ToLower(v)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value %q in cron field %q", v, field)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i != -1 {
+			var err error
+			rng = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in cron field %q", field)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in cron field %q", rng, field)
+			}
+		default:
+			var err error
+			if lo, err = value(rng); err != nil {
+				return 0, err
+			}
+			// a single value with a step runs from it to the end of the range
+			if step == 1 {
+				hi = lo
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first scheduled time after t, or the zero time if the
+// schedule never fires (e.g. February 30th). Around daylight saving changes a
+// time the clocks skip does not fire, and a time they go through twice fires
+// only the first time.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute).In(s.loc)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		if first := firstOccurrence(t); first.After(after) {
+			return first
+		}
+		// the first time the clocks showed this time has been scheduled already
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// firstOccurrence returns the first instant the wall clock showed the time of
+// t, an hour earlier than t when t falls in the hour repeated after the clocks
+// were set back.
+func firstOccurrence(t time.Time) time.Time {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return t
+	}
+	_, offset := t.Zone()
+	_, before := start.Add(-time.Second).Zone()
+	shift := time.Duration(before-offset) * time.Second
+	if shift > 0 && t.Sub(start) < shift {
+		return t.Add(-shift)
+	}
+	return t
+}
@@ -0,0 +1,203 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  bool
+	}{
+		{spec: "* * * * *"},
+		{spec: "@daily"},
+		{spec: " @hourly "},
+		{spec: "0-59/15 0,12 1-31 jan-dec mon-fri"},
+		{spec: "5/10 * * * *"},
+		{spec: "0 0 * * 7"},
+		{spec: "* * * *", err: true},
+		{spec: "* * * * * *", err: true},
+		{spec: "60 * * * *", err: true},
+		{spec: "* 24 * * *", err: true},
+		{spec: "* * 0 * *", err: true},
+		{spec: "* * * 13 *", err: true},
+		{spec: "* * * * 8", err: true},
+		{spec: "30-10 * * * *", err: true},
+		{spec: "*/0 * * * *", err: true},
+		{spec: "*/x * * * *", err: true},
+		{spec: "* * * foo *", err: true},
+		{spec: "@fortnightly", err: true},
+	}
+	for _, tt := range tests {
+		_, err := ParseCronSchedule(tt.spec, time.UTC)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseCronSchedule(%q) error = %v, want error %v", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	local := func(s string, offset string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04 -0700", s+" "+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		loc  *time.Location
+		from time.Time
+		want []time.Time
+	}{
+		{
+			name: "every minute starts at the next whole minute",
+			spec: "* * * * *",
+			from: utc("2026-01-01 10:00").Add(30 * time.Second),
+			want: []time.Time{utc("2026-01-01 10:01"), utc("2026-01-01 10:02")},
+		},
+		{
+			name: "step over the whole range",
+			spec: "*/20 * * * *",
+			from: utc("2026-01-01 10:05"),
+			want: []time.Time{utc("2026-01-01 10:20"), utc("2026-01-01 10:40"), utc("2026-01-01 11:00")},
+		},
+		{
+			name: "step over a range",
+			spec: "10-30/10 9 * * *",
+			from: utc("2026-01-01 09:00"),
+			want: []time.Time{utc("2026-01-01 09:10"), utc("2026-01-01 09:20"), utc("2026-01-01 09:30"), utc("2026-01-02 09:10")},
+		},
+		{
+			name: "step from a single value",
+			spec: "45/5 * * * *",
+			from: utc("2026-01-01 10:00"),
+			want: []time.Time{utc("2026-01-01 10:45"), utc("2026-01-01 10:50"), utc("2026-01-01 10:55"), utc("2026-01-01 11:45")},
+		},
+		{
+			name: "list and names",
+			spec: "0 8,17 * * mon-fri",
+			// Friday
+			from: utc("2026-01-02 12:00"),
+			want: []time.Time{utc("2026-01-02 17:00"), utc("2026-01-05 08:00")},
+		},
+		{
+			name: "7 is Sunday",
+			spec: "0 0 * * 7",
+			from: utc("2026-01-01 00:00"),
+			want: []time.Time{utc("2026-01-04 00:00"), utc("2026-01-11 00:00")},
+		},
+		{
+			name: "restricted day of month and day of week match either",
+			// the 13th or any Friday
+			spec: "0 0 13 * fri",
+			from: utc("2026-01-10 00:00"),
+			// Tuesday the 13th, then Friday the 16th
+			want: []time.Time{utc("2026-01-13 00:00"), utc("2026-01-16 00:00"), utc("2026-01-23 00:00")},
+		},
+		{
+			name: "a starred day field makes the other one decide",
+			spec: "0 0 */10 * *",
+			from: utc("2026-02-01 00:00"),
+			want: []time.Time{utc("2026-02-11 00:00"), utc("2026-02-21 00:00"), utc("2026-03-01 00:00")},
+		},
+		{
+			name: "day of week with a starred day of month",
+			spec: "0 0 * * fri",
+			from: utc("2026-02-01 00:00"),
+			want: []time.Time{utc("2026-02-06 00:00"), utc("2026-02-13 00:00")},
+		},
+		{
+			name: "months without the day are skipped",
+			spec: "@monthly",
+			from: utc("2026-01-31 12:00"),
+			want: []time.Time{utc("2026-02-01 00:00"), utc("2026-03-01 00:00")},
+		},
+		{
+			name: "February 29th",
+			spec: "0 0 29 2 *",
+			from: utc("2026-01-01 00:00"),
+			want: []time.Time{utc("2028-02-29 00:00")},
+		},
+		{
+			name: "never",
+			spec: "0 0 30 2 *",
+			from: utc("2026-01-01 00:00"),
+			want: []time.Time{{}},
+		},
+		{
+			name: "time zone",
+			spec: "0 9 * * *",
+			loc:  berlin,
+			from: utc("2026-01-01 00:00"),
+			want: []time.Time{utc("2026-01-01 08:00")},
+		},
+		{
+			name: "the hour skipped in spring does not fire",
+			spec: "30 2 * * *",
+			loc:  berlin,
+			from: local("2026-03-28 03:00", "+0100"),
+			want: []time.Time{local("2026-03-30 02:30", "+0200")},
+		},
+		{
+			name: "hourly in spring",
+			spec: "0 * * * *",
+			loc:  berlin,
+			from: local("2026-03-29 00:30", "+0100"),
+			want: []time.Time{local("2026-03-29 01:00", "+0100"), local("2026-03-29 03:00", "+0200")},
+		},
+		{
+			name: "the hour repeated in autumn fires once",
+			spec: "30 2 * * *",
+			loc:  berlin,
+			from: local("2026-10-24 03:00", "+0200"),
+			want: []time.Time{local("2026-10-25 02:30", "+0200"), local("2026-10-26 02:30", "+0100")},
+		},
+		{
+			name: "steps through the hour repeated in autumn",
+			spec: "*/30 1-3 * * *",
+			loc:  berlin,
+			from: local("2026-10-25 01:45", "+0200"),
+			want: []time.Time{
+				local("2026-10-25 02:00", "+0200"),
+				local("2026-10-25 02:30", "+0200"),
+				local("2026-10-25 03:00", "+0100"),
+				local("2026-10-25 03:30", "+0100"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := tt.loc
+			if loc == nil {
+				loc = time.UTC
+			}
+			s, err := ParseCronSchedule(tt.spec, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := tt.from
+			for _, want := range tt.want {
+				got := s.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("Next(%v) = %v, want %v", from, got, want)
+				}
+				from = got
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package task
+
+import (
+	"os"
+	"syscall"
+)
+
+// processAttr makes the process start in a process group of its own, so a stop
+// reaches whatever it forked, and in the cgroup dir is open on, if any.
+func processAttr(cgroupDir *os.File) (*syscall.SysProcAttr, error) {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if cgroupDir != nil {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(cgroupDir.Fd())
+	}
+	return attr, nil
+}
+
+// signalGroup sends sig to the process group the process leads.
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	return syscall.Kill(-p.Pid, sig)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package task
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// processAttr fails for a process that needs a cgroup, cgroups only exist on
+// Linux.
+func processAttr(cgroupDir *os.File) (*syscall.SysProcAttr, error) {
+	if cgroupDir != nil {
+		return nil, errors.New("cgroups are only supported on Linux")
+	}
+	return nil, nil
+}
+
+// signalGroup only reaches the process itself, what it forked is left running.
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	return p.Signal(sig)
+}
@@ -0,0 +1,493 @@
+package task
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ExecRuntime runs a task as a plain process supervised by the worker instead of
+// a container. Task.Entrypoint followed by Task.Cmd make up the command line:
+// the first element is the binary, the rest are its arguments. Memory, CPU,
+// pids, cpuset and swap limits are applied through cgroup v2; a task asking for
+// a limit the host cannot apply is rejected (see Capabilities).
+//
+// The processes are only known to the ExecRuntime that started them. A worker
+// that restarts reports its exec tasks as Failed, their container not found,
+// while their processes keep running: they have to be killed by hand, e.g.
+// through their cgroup under /sys/fs/cgroup/cube. Workers sharing a host share
+// that cgroup, so a restarting one cannot tell its leftovers from the others'.
+type ExecRuntime struct {
+	// Dir is where the output of every process is kept, one file per process.
+	Dir       string
+	mu        sync.Mutex
+	processes map[string]*process
+	// controllers are the cgroup v2 controllers available to the processes.
+	controllers map[string]bool
+}
+
+type process struct {
+	cmd     *exec.Cmd
+	state   ContainerState
+	logFile string
+	cgroup  string
+	done    chan struct{}
+}
+
+func NewExecRuntime(dir string) *ExecRuntime {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Error creating directory %s for exec tasks: %v\n", dir, err)
+	}
+	r := ExecRuntime{
+		Dir:         dir,
+		processes:   make(map[string]*process),
+		controllers: setupCgroupParent(),
+	}
+	if len(r.controllers) == 0 {
+		log.Println("cgroup v2 is not available, exec tasks with resource limits will be rejected")
+	}
+	return &r
+}
+
+// ValidateExecTask rejects what the exec driver cannot run: a task without a
+// command, mounts, configs and secrets mounted as files (the worker bind mounts
+// them), task groups and a User, processes run as the worker's user.
+func ValidateExecTask(t Task) error {
+	if len(t.Entrypoint)+len(t.Cmd) == 0 {
+		return fmt.Errorf("task %s has no command to execute", t.ID)
+	}
+	if len(t.Mounts) > 0 {
+		return fmt.Errorf("task %s declares mounts, which the exec driver does not support", t.ID)
+	}
+	if len(t.Configs) > 0 {
+		return fmt.Errorf("task %s mounts configs, which the exec driver does not support", t.ID)
+	}
+	for _, ref := range t.Secrets {
+		if ref.File != "" {
+			return fmt.Errorf("task %s mounts secret %s as a file, which the exec driver does not support", t.ID, ref.Name)
+		}
+	}
+	if len(t.Containers) > 0 {
+		return fmt.Errorf("task %s is a task group, which the exec driver does not support", t.ID)
+	}
+	if t.User != "" {
+		return fmt.Errorf("task %s sets User %q, the exec driver runs processes as the worker's user", t.ID, t.User)
+	}
+	return nil
+}
+
+func (r *ExecRuntime) Run(t Task) DockerResult {
+	if err := ValidateExecTask(t); err != nil {
+		return DockerResult{Error: err}
+	}
+	argv := append(append([]string{}, t.Entrypoint...), t.Cmd...)
+
+	id := uuid.New().String()
+	logFile := filepath.Join(r.Dir, fmt.Sprintf("%s.log", id))
+	out, err := os.Create(logFile)
+	if err != nil {
+		log.Printf("Error creating log file for task %s: %v\n", t.ID, err)
+		return DockerResult{Error: err}
+	}
+
+	// the process gets the worker's environment, PATH included, with the
+	// task's variables taking precedence
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), t.Env...)
+	cmd.Dir = t.WorkingDir
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	var cgroup string
+	if hasLimits(t) {
+		cgroup, err = createCgroup(id, t)
+		if err != nil {
+			log.Printf("Error creating cgroup for task %s: %v\n", t.ID, err)
+			out.Close()
+			os.Remove(logFile)
+			return DockerResult{Error: err}
+		}
+	}
+
+	// the process is started right in its cgroup, so nothing it runs escapes the
+	// limits, and in a process group of its own
+	var cgroupDir *os.File
+	if cgroup != "" {
+		cgroupDir, err = os.Open(cgroup)
+	}
+	if err == nil {
+		cmd.SysProcAttr, err = processAttr(cgroupDir)
+	}
+	if err != nil {
+		log.Printf("Error opening cgroup for task %s: %v\n", t.ID, err)
+		if cgroupDir != nil {
+			cgroupDir.Close()
+		}
+		out.Close()
+		os.Remove(logFile)
+		removeCgroup(cgroup)
+		return DockerResult{Error: err}
+	}
+
+	err = cmd.Start()
+	if cgroupDir != nil {
+		cgroupDir.Close()
+	}
+	if err != nil {
+		log.Printf("Error starting process %s for task %s: %v\n", argv[0], t.ID, err)
+		out.Close()
+		os.Remove(logFile)
+		removeCgroup(cgroup)
+		return DockerResult{Error: err}
+	}
+
+	p := &process{
+		cmd:     cmd,
+		logFile: logFile,
+		cgroup:  cgroup,
+		done:    make(chan struct{}),
+		state: ContainerState{
+			Status:    "running",
+			Running:   true,
+			StartedAt: time.Now(),
+			HostPorts: t.PortBindings,
+		},
+	}
+
+	r.mu.Lock()
+	r.processes[id] = p
+	r.mu.Unlock()
+
+	go r.supervise(p, out)
+
+	return DockerResult{ContainerId: id, Action: "start", Result: "success"}
+}
+
+func (r *ExecRuntime) supervise(p *process, out *os.File) {
+	p.cmd.Wait()
+	out.Close()
+
+	r.mu.Lock()
+	p.state.Status = "exited"
+	p.state.Running = false
+	p.state.ExitCode = p.cmd.ProcessState.ExitCode()
+	p.state.FinishedAt = time.Now()
+	p.state.OOMKilled = oomKilled(p.cgroup)
+	r.mu.Unlock()
+
+	close(p.done)
+}
+
+func (r *ExecRuntime) Stop(t Task) DockerResult {
+	log.Printf("Attempting to stop process %v\n", t.ContainerID)
+	p, err := r.process(t.ContainerID)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	// the processes the task forked may outlive its main one, they get the
+	// stop signal too and are killed with it after the grace period
+	sig, err := ParseStopSignal(t.StopSignal)
+	if err != nil {
+		sig = syscall.SIGTERM
+	}
+	if err := signalGroup(p.cmd.Process, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		log.Printf("Error signalling process %v: %v\n", t.ContainerID, err)
+	}
+	select {
+	case <-p.done:
+	case <-time.After(t.GracePeriod()):
+	}
+	killProcesses(p)
+	<-p.done
+
+	r.mu.Lock()
+	delete(r.processes, t.ContainerID)
+	r.mu.Unlock()
+	removeCgroup(p.cgroup)
+	os.Remove(p.logFile)
+
+	return DockerResult{Action: "stop", Result: "success"}
+}
+
+func (r *ExecRuntime) Inspect(t Task) InspectResponse {
+	p, err := r.process(t.ContainerID)
+	if err != nil {
+		return InspectResponse{Error: err}
+	}
+
+	r.mu.Lock()
+	s := p.state
+	r.mu.Unlock()
+	return InspectResponse{State: &s}
+}
+
+func (r *ExecRuntime) Logs(t Task, opts LogOptions) (io.ReadCloser, error) {
+	p, err := r.process(t.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p.logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Tail != "" && opts.Tail != "all" {
+		n, err := strconv.Atoi(opts.Tail)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("invalid tail value %q", opts.Tail)
+		}
+		if err := seekTail(f, n); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	if !opts.Follow {
+		return f, nil
+	}
+	return &followReader{f: f, done: p.done}, nil
+}
+
+func (r *ExecRuntime) Wait(t Task) InspectResponse {
+	p, err := r.process(t.ContainerID)
+	if err != nil {
+		return InspectResponse{Error: err}
+	}
+	<-p.done
+	return r.Inspect(t)
+}
+
+// Capabilities reports the limits the available cgroup v2 controllers can apply.
+// Disk sizes and ulimits are never supported for plain processes.
+func (r *ExecRuntime) Capabilities() (Capabilities, error) {
+	_, err := os.Stat(filepath.Join(cgroupParent, "memory.swap.max"))
+	return Capabilities{
+		Cpu:    r.controllers["cpu"],
+		Memory: r.controllers["memory"],
+		Pids:   r.controllers["pids"],
+		Cpuset: r.controllers["cpuset"],
+		Swap:   r.controllers["memory"] && err == nil,
+	}, nil
+}
+
+func (r *ExecRuntime) process(id string) (*process, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.processes[id]
+	if !ok {
+		return nil, fmt.Errorf("no such process: %s", id)
+	}
+	return p, nil
+}
+
+// seekTail positions f at the start of its last n lines.
+func seekTail(f *os.File, n int) error {
+	var offsets []int64
+	var offset int64
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		offsets = append(offsets, offset)
+		offset += int64(len(s.Bytes())) + 1
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	start := int64(0)
+	if n < len(offsets) {
+		start = offsets[len(offsets)-n]
+	}
+	_, err := f.Seek(start, io.SeekStart)
+	return err
+}
+
+// followReader keeps reading a log file as it grows until the process exits.
+type followReader struct {
+	f    *os.File
+	done chan struct{}
+}
+
+func (fr *followReader) Read(b []byte) (int, error) {
+	for {
+		n, err := fr.f.Read(b)
+		if n > 0 || !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		select {
+		case <-fr.done:
+			return fr.f.Read(b)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func (fr *followReader) Close() error {
+	return fr.f.Close()
+}
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+var cgroupParent = filepath.Join(cgroupRoot, "cube")
+
+// setupCgroupParent creates the cgroup all exec tasks live under and enables as
+// many of the controllers they need as possible. It returns the enabled ones,
+// none when cgroup v2 is not usable.
+func setupCgroupParent() map[string]bool {
+	enabled := make(map[string]bool)
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return enabled
+	}
+	if err := os.MkdirAll(cgroupParent, 0755); err != nil {
+		return enabled
+	}
+	for _, c := range []string{"cpu", "memory", "pids", "cpuset"} {
+		// enabling a controller on the root may fail if it already is, so only the
+		// second write decides whether the limit can be applied.
+		writeCgroupFile(cgroupRoot, "cgroup.subtree_control", "+"+c)
+		if err := writeCgroupFile(cgroupParent, "cgroup.subtree_control", "+"+c); err == nil {
+			enabled[c] = true
+		}
+	}
+	return enabled
+}
+
+func hasLimits(t Task) bool {
+	return t.Cpu > 0 || t.Memory > 0 || t.PidsLimit > 0 || t.CpusetCpus != "" || t.MemorySwap != 0
+}
+
+func createCgroup(id string, t Task) (string, error) {
+	dir := filepath.Join(cgroupParent, id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+
+	var limits [][2]string
+	if t.Memory > 0 {
+		limits = append(limits, [2]string{"memory.max", strconv.FormatInt(t.Memory, 10)})
+	}
+	if t.Cpu > 0 {
+		period := 100000
+		quota := int(t.Cpu * float64(period))
+		limits = append(limits, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, period)})
+	}
+	if t.PidsLimit > 0 {
+		limits = append(limits, [2]string{"pids.max", strconv.FormatInt(t.PidsLimit, 10)})
+	}
+	if t.CpusetCpus != "" {
+		limits = append(limits, [2]string{"cpuset.cpus", t.CpusetCpus})
+	}
+	// MemorySwap is memory plus swap like in docker, cgroup v2 limits the swap alone.
+	if t.MemorySwap == -1 {
+		limits = append(limits, [2]string{"memory.swap.max", "max"})
+	} else if t.MemorySwap > 0 {
+		if t.MemorySwap < t.Memory {
+			removeCgroup(dir)
+			return "", fmt.Errorf("MemorySwap %d is lower than Memory %d", t.MemorySwap, t.Memory)
+		}
+		limits = append(limits, [2]string{"memory.swap.max", strconv.FormatInt(t.MemorySwap-t.Memory, 10)})
+	}
+
+	for _, l := range limits {
+		if err := writeCgroupFile(dir, l[0], l[1]); err != nil {
+			removeCgroup(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// killProcesses kills whatever is left of the task's processes: everything in
+// its cgroup through cgroup.kill, and its process group, which is all there is
+// for a task without limits.
+func killProcesses(p *process) {
+	if p.cgroup != "" {
+		if err := writeCgroupFile(p.cgroup, "cgroup.kill", "1"); err != nil {
+			log.Printf("Error killing the processes in cgroup %s: %v\n", p.cgroup, err)
+		}
+	}
+	if err := signalGroup(p.cmd.Process, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		p.cmd.Process.Kill()
+	}
+}
+
+// removeCgroup removes the cgroup once the processes killed in it are gone, the
+// kernel refuses to remove a populated one.
+func removeCgroup(dir string) {
+	if dir == "" {
+		return
+	}
+	for deadline := time.Now().Add(time.Second); cgroupPopulated(dir) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Remove(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error removing cgroup %s: %v\n", dir, err)
+	}
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+func cgroupPopulated(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "populated" {
+			return fields[1] != "0"
+		}
+	}
+	return false
+}
+
+func oomKilled(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return fields[1] != "0"
+		}
+	}
+	return false
+}
+
+// RunCommand runs the command on the worker with the task's environment, the
+// way the task itself runs.
+func (r *ExecRuntime) RunCommand(ctx context.Context, t Task, cmd []string) (int, error) {
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Env = append(os.Environ(), t.Env...)
+	c.Dir = t.WorkingDir
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,138 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateExecTask(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		ok   bool
+	}{
+		{name: "command", task: Task{Cmd: []string{"true"}}, ok: true},
+		{name: "entrypoint", task: Task{Entrypoint: []string{"sleep"}, Cmd: []string{"1"}}, ok: true},
+		{name: "no command", task: Task{}},
+		{name: "mounts", task: Task{Cmd: []string{"true"}, Mounts: []Mount{{Type: MountTmpfs, Target: "/tmp"}}}},
+		{name: "group", task: Task{Cmd: []string{"true"}, Containers: []Container{{Name: "proxy", Image: "nginx"}}}},
+		{name: "user", task: Task{Cmd: []string{"true"}, User: "nobody"}},
+		{name: "env secret", task: Task{Cmd: []string{"true"}, Secrets: []SecretRef{{Name: "db", Env: "DB_PASSWORD"}}}, ok: true},
+		{name: "config", task: Task{Cmd: []string{"true"}, Configs: []ConfigRef{{Name: "nginx", File: "/etc/nginx.conf"}}}},
+		{name: "file secret", task: Task{Cmd: []string{"true"}, Secrets: []SecretRef{{Name: "db", File: "/run/secrets/db"}}}},
+	}
+	for _, tt := range tests {
+		if err := ValidateExecTask(tt.task); (err == nil) != tt.ok {
+			t.Errorf("%s: error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestExecRuntimeInheritsEnvironment(t *testing.T) {
+	if os.Getenv("PATH") == "" {
+		t.Skip("no PATH to inherit")
+	}
+	r := NewExecRuntime(t.TempDir())
+	tk := Task{
+		ID:  uuid.New(),
+		Cmd: []string{"sh", "-c", `echo "path=$PATH"; echo "greeting=$GREETING"`},
+		Env: []string{"GREETING=hello"},
+	}
+
+	// sh is found through the worker's PATH
+	result := r.Run(tk)
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	tk.ContainerID = result.ContainerId
+	defer r.Stop(tk)
+	if resp := r.Wait(tk); resp.State.ExitCode != 0 {
+		t.Fatalf("process exited with code %d", resp.State.ExitCode)
+	}
+
+	logs, err := r.Logs(tk, LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	out, _ := io.ReadAll(logs)
+	for _, want := range []string{"path=" + os.Getenv("PATH"), "greeting=hello"} {
+		if !strings.Contains(string(out), want+"\n") {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestExecRuntimeStopKillsForkedProcesses(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{name: "main process running", script: `sleep 60 & echo $!; wait`},
+		{name: "main process exited", script: `sleep 60 & echo $!`},
+	}
+	for _, tt := range tests {
+		r := NewExecRuntime(t.TempDir())
+		tk := Task{ID: uuid.New(), Cmd: []string{"sh", "-c", tt.script}}
+		result := r.Run(tk)
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		tk.ContainerID = result.ContainerId
+
+		child := forkedPid(t, r, tk)
+		if !processAlive(child) {
+			t.Fatalf("%s: forked process %d is not running", tt.name, child)
+		}
+		if result := r.Stop(tk); result.Error != nil {
+			t.Fatalf("%s: %v", tt.name, result.Error)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for processAlive(child) {
+			if time.Now().After(deadline) {
+				syscall.Kill(child, syscall.SIGKILL)
+				t.Fatalf("%s: forked process %d survived the stop", tt.name, child)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// forkedPid waits for the task to print the pid of the process it forked.
+func forkedPid(t *testing.T, r *ExecRuntime, tk Task) int {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		logs, err := r.Logs(tk, LogOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(logs)
+		logs.Close()
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(out))); err == nil {
+			return pid
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the task did not print the pid of its child")
+	return 0
+}
+
+// processAlive reports whether the process exists and is not a zombie.
+func processAlive(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// the state follows the command name, which is in parentheses
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
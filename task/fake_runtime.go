@@ -0,0 +1,169 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/uuid"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRuntime is an in-memory Runtime that never talks to a container engine.
+// It lets the worker's state handling be exercised without a Docker daemon;
+// Exit and SetLogs drive the "containers" from the outside.
+type FakeRuntime struct {
+	mu         sync.Mutex
+	containers map[string]*ContainerState
+	env        map[string][]string
+	logs       map[string]string
+	done       map[string]chan struct{}
+	// RunError, when set, makes every call to Run fail with it.
+	RunError error
+	// CommandExitCode is what RunCommand returns for every command.
+	CommandExitCode int
+	// CapabilitiesError, when set, makes Capabilities fail with it.
+	CapabilitiesError error
+}
+
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		containers: make(map[string]*ContainerState),
+		env:        make(map[string][]string),
+		logs:       make(map[string]string),
+		done:       make(map[string]chan struct{}),
+	}
+}
+
+func (f *FakeRuntime) Run(t Task) DockerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.RunError != nil {
+		return DockerResult{Error: f.RunError}
+	}
+
+	id := uuid.New().String()
+	f.containers[id] = &ContainerState{
+		Status:    "running",
+		Running:   true,
+		StartedAt: time.Now(),
+		HostPorts: t.PortBindings,
+	}
+	f.env[id] = append([]string(nil), t.Env...)
+	f.done[id] = make(chan struct{})
+
+	// the extra containers of a group are not tracked, they only get an id
+	var group []string
+	for range t.Containers {
+		group = append(group, uuid.New().String())
+	}
+
+	return DockerResult{ContainerId: id, Action: "start", Result: "success", GroupContainerIds: group}
+}
+
+func (f *FakeRuntime) Stop(t Task) DockerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.containers[t.ContainerID]; !ok {
+		return DockerResult{Error: fmt.Errorf("no such container: %s", t.ContainerID)}
+	}
+	f.exit(t.ContainerID, 0)
+	delete(f.containers, t.ContainerID)
+	delete(f.env, t.ContainerID)
+	delete(f.logs, t.ContainerID)
+
+	return DockerResult{Action: "stop", Result: "success"}
+}
+
+func (f *FakeRuntime) Inspect(t Task) InspectResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[t.ContainerID]
+	if !ok {
+		return InspectResponse{Error: fmt.Errorf("no such container: %s", t.ContainerID)}
+	}
+	s := *c
+	// like docker's, the inspect response shows the environment the container runs with
+	resp := &types.ContainerJSON{Config: &container.Config{Env: append([]string(nil), f.env[t.ContainerID]...)}}
+	return InspectResponse{State: &s, Container: resp}
+}
+
+func (f *FakeRuntime) Logs(t Task, opts LogOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.containers[t.ContainerID]; !ok {
+		return nil, fmt.Errorf("no such container: %s", t.ContainerID)
+	}
+	return io.NopCloser(strings.NewReader(f.logs[t.ContainerID])), nil
+}
+
+func (f *FakeRuntime) Wait(t Task) InspectResponse {
+	f.mu.Lock()
+	done, ok := f.done[t.ContainerID]
+	f.mu.Unlock()
+	if !ok {
+		return InspectResponse{Error: fmt.Errorf("no such container: %s", t.ContainerID)}
+	}
+
+	<-done
+	return f.Inspect(t)
+}
+
+func (f *FakeRuntime) RunCommand(ctx context.Context, t Task, cmd []string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.containers[t.ContainerID]; !ok {
+		return 0, fmt.Errorf("no such container: %s", t.ContainerID)
+	}
+	return f.CommandExitCode, nil
+}
+
+// Capabilities reports every limit as enforceable, the fake never rejects a task
+// unless CapabilitiesError is set.
+func (f *FakeRuntime) Capabilities() (Capabilities, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.CapabilitiesError != nil {
+		return Capabilities{}, f.CapabilitiesError
+	}
+	return Capabilities{Cpu: true, Memory: true, Disk: true, Pids: true, Cpuset: true, Swap: true, Ulimits: true}, nil
+}
+
+// Exit simulates the container's main process exiting with the given code.
+func (f *FakeRuntime) Exit(containerID string, exitCode int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.containers[containerID]; !ok {
+		return fmt.Errorf("no such container: %s", containerID)
+	}
+	f.exit(containerID, exitCode)
+	return nil
+}
+
+// SetLogs replaces the output returned by Logs for the container.
+func (f *FakeRuntime) SetLogs(containerID string, output string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs[containerID] = output
+}
+
+func (f *FakeRuntime) exit(containerID string, exitCode int) {
+	c := f.containers[containerID]
+	if !c.Running {
+		return
+	}
+	c.Status = "exited"
+	c.Running = false
+	c.ExitCode = exitCode
+	c.FinishedAt = time.Now()
+	close(f.done[containerID])
+}
@@ -0,0 +1,224 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"log"
+	"sync"
+)
+
+/*
+  - Container is an extra container of a task group, e.g. a log shipper or a proxy sidecar.
+    The task's own Image, Cmd etc. describe the main container of the group.
+
+  - The containers of a group run on the same worker, join the main container's network
+    namespace (so they reach each other on localhost) and mount the task's Mounts. Ports are
+    published by the main container only.
+
+  - The group is started and stopped as a unit: it fails when a container fails to start, and a
+    container exiting with a non-zero code fails the whole group. ID is set by the worker.
+*/
+type Container struct {
+	Name            string
+	ID              string
+	Image           string
+	ImagePullPolicy string
+	Entrypoint      []string
+	Cmd             []string
+	Env             []string
+	WorkingDir      string
+	User            string
+	Cpu             float64
+	Memory          int64
+	Disk            int64
+}
+
+// Resources is what a task needs from a worker to run.
+type Resources struct {
+	Cpu    float64
+	Memory int64
+	Disk   int64
+}
+
+// Resources returns the task's requests summed over the containers of its group.
+func (t Task) Resources() Resources {
+	r := Resources{Cpu: t.Cpu, Memory: t.Memory, Disk: t.Disk}
+	for _, c := range t.Containers {
+		r.Cpu += c.Cpu
+		r.Memory += c.Memory
+		r.Disk += c.Disk
+	}
+	return r
+}
+
+// ValidateContainers checks that the containers of a group can be told apart
+// and each names an image.
+func ValidateContainers(t Task) error {
+	names := make(map[string]bool)
+	for _, c := range t.Containers {
+		if c.Name == "" || !serviceNameRe.MatchString(c.Name) {
+			return fmt.Errorf("container name %q must be lower case letters, digits and dashes", c.Name)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate container name %q", c.Name)
+		}
+		names[c.Name] = true
+		if c.Image == "" {
+			return fmt.Errorf("container %s has no image", c.Name)
+		}
+		if !ValidPullPolicy(c.ImagePullPolicy) {
+			return fmt.Errorf("unknown image pull policy %q for container %s", c.ImagePullPolicy, c.Name)
+		}
+	}
+	return nil
+}
+
+// ContainerIDOf returns the id of the group's container with the given name, the
+// main container's for an empty name.
+func (t Task) ContainerIDOf(name string) (string, error) {
+	if name == "" {
+		return t.ContainerID, nil
+	}
+	for _, c := range t.Containers {
+		if c.Name == name {
+			return c.ID, nil
+		}
+	}
+	return "", fmt.Errorf("task %s has no container named %q", t.ID, name)
+}
+
+// sidecar returns the docker driver for one of the group's extra containers,
+// attached to the network namespace of the main container. It never removes the
+// group's named volumes, the main container's driver does.
+func (r *DockerRuntime) sidecar(t Task, c Container, mainID string) *Docker {
+	s := t
+	s.Name = fmt.Sprintf("%s-%s", t.Name, c.Name)
+	s.Image = c.Image
+	s.ImagePullPolicy = c.ImagePullPolicy
+	s.Entrypoint = c.Entrypoint
+	s.Cmd = c.Cmd
+	s.Env = c.Env
+	s.WorkingDir = c.WorkingDir
+	s.User = c.User
+	s.Cpu = c.Cpu
+	s.Memory = c.Memory
+	s.Disk = c.Disk
+	s.PidsLimit = 0
+	s.CpusetCpus = ""
+	s.MemorySwap = 0
+	s.Ulimits = nil
+	s.ExposedPort = nil
+	s.PortBindings = nil
+	s.NetworkMode = container.NetworkMode("container:" + mainID)
+	s.VolumeRetention = RetainVolumes
+	return r.docker(s)
+}
+
+// runGroup starts the extra containers of a group whose main container is
+// already running. If one of them fails, the ones started so far are stopped
+// and the error returned, the caller stops the main container.
+func (r *DockerRuntime) runGroup(t Task, mainID string) ([]string, error) {
+	var ids []string
+	for _, c := range t.Containers {
+		result := r.sidecar(t, c, mainID).Run()
+		if result.Error != nil {
+			log.Printf("Error starting container %s of task %s: %v\n", c.Name, t.ID, result.Error)
+			for i, id := range ids {
+				r.sidecar(t, t.Containers[i], mainID).Stop(id)
+			}
+			return nil, fmt.Errorf("container %s: %v", c.Name, result.Error)
+		}
+		ids = append(ids, result.ContainerId)
+	}
+	return ids, nil
+}
+
+// signalGroup stops the containers of a group in parallel within the task's
+// grace period, without removing them. Removing them afterwards finds them
+// already stopped.
+func (r *DockerRuntime) signalGroup(t Task) {
+	if len(t.Containers) == 0 {
+		return
+	}
+	timeout := t.GracePeriod()
+	ids := []string{t.ContainerID}
+	for _, c := range t.Containers {
+		if c.ID != "" {
+			ids = append(ids, c.ID)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := r.Client.ContainerStop(context.Background(), id, &timeout); err != nil {
+				log.Printf("Error stopping container %s of task %s: %v\n", id, t.ID, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// stopGroup stops and removes the extra containers of a group, the ones that
+// are already gone are skipped.
+func (r *DockerRuntime) stopGroup(t Task) {
+	for _, c := range t.Containers {
+		if c.ID == "" {
+			continue
+		}
+		if result := r.sidecar(t, c, t.ContainerID).Stop(c.ID); result.Error != nil {
+			log.Printf("Error stopping container %s of task %s: %v\n", c.Name, t.ID, result.Error)
+		}
+	}
+}
+
+// groupState folds the state of the extra containers into the main container's:
+// while the main container runs, a container that exited with a non-zero code
+// (or was killed for memory) makes the whole group exited.
+func (r *DockerRuntime) groupState(t Task, s *ContainerState) {
+	if s == nil || !s.Running {
+		return
+	}
+	for _, c := range t.Containers {
+		if c.ID == "" {
+			continue
+		}
+		resp := r.docker(t).Inspect(c.ID)
+		if resp.Error != nil {
+			continue
+		}
+		cs := containerState(resp)
+		if cs == nil || cs.Running || (cs.ExitCode == 0 && !cs.OOMKilled) {
+			continue
+		}
+		log.Printf("Container %s of task %s exited with code %d\n", c.Name, t.ID, cs.ExitCode)
+		s.Status = "exited"
+		s.Running = false
+		s.ExitCode = cs.ExitCode
+		s.OOMKilled = cs.OOMKilled
+		s.FinishedAt = cs.FinishedAt
+		return
+	}
+}
+
+// StopGroup stops the containers of a group that are still running once the
+// group has exited, without removing them, so their logs stay available.
+func (r *DockerRuntime) StopGroup(t Task) error {
+	ctx := context.Background()
+	ids := []string{t.ContainerID}
+	for _, c := range t.Containers {
+		if c.ID != "" {
+			ids = append(ids, c.ID)
+		}
+	}
+	var lastErr error
+	for _, id := range ids {
+		if err := r.Client.ContainerStop(ctx, id, nil); err != nil {
+			log.Printf("Error stopping container %s of task %s: %v\n", id, t.ID, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,116 @@
+package task
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	MountVolume = "volume"
+	MountBind   = "bind"
+	MountTmpfs  = "tmpfs"
+)
+
+// Volume retention policies, applied to a task's named volumes when it is stopped.
+const (
+	DeleteVolumes = "delete"
+	RetainVolumes = "retain"
+)
+
+func ValidVolumeRetention(r string) bool {
+	switch r {
+	case "", DeleteVolumes, RetainVolumes:
+		return true
+	}
+	return false
+}
+
+// Mount declares storage for a task. Source is the volume name for "volume"
+// mounts and the host path for "bind" mounts; it is ignored for "tmpfs" mounts,
+// which may set Size in bytes instead.
+type Mount struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+	Size     int64
+}
+
+func (m Mount) Validate() error {
+	if m.Target == "" {
+		return fmt.Errorf("mount of type %q has no target", m.Type)
+	}
+	switch m.Type {
+	case MountVolume, MountBind:
+		if m.Source == "" {
+			return fmt.Errorf("%s mount on %s has no source", m.Type, m.Target)
+		}
+	case MountTmpfs:
+	default:
+		return fmt.Errorf("unknown mount type %q for %s", m.Type, m.Target)
+	}
+	return nil
+}
+
+// CheckBindMounts makes sure every bind mount of the task uses a host path within
+// one of the allowed paths. Symlinks are resolved first, so a link inside an
+// allowed path cannot point the mount anywhere else on the host.
+func CheckBindMounts(mounts []Mount, allowed []string) error {
+	for _, m := range mounts {
+		if m.Type != MountBind {
+			continue
+		}
+		if !filepath.IsAbs(m.Source) {
+			return fmt.Errorf("bind mount of host path %s is not allowed on this worker", m.Source)
+		}
+		path, err := filepath.EvalSymlinks(m.Source)
+		if err != nil {
+			return fmt.Errorf("bind mount of host path %s: %v", m.Source, err)
+		}
+		if !pathAllowed(path, allowed) {
+			return fmt.Errorf("bind mount of host path %s is not allowed on this worker", m.Source)
+		}
+	}
+	return nil
+}
+
+func pathAllowed(path string, allowed []string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	path = filepath.Clean(path)
+	for _, a := range allowed {
+		// an allowed path that is a link itself allows where it points to
+		if resolved, err := filepath.EvalSymlinks(a); err == nil {
+			a = resolved
+		}
+		rel, err := filepath.Rel(filepath.Clean(a), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
+func dockerMounts(mounts []Mount) ([]mount.Mount, error) {
+	var dm []mount.Mount
+	for _, m := range mounts {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		d := mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Type == MountTmpfs {
+			d.Source = ""
+			d.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: m.Size}
+		}
+		dm = append(dm, d)
+	}
+	return dm, nil
+}
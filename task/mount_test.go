@@ -0,0 +1,51 @@
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckBindMounts(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "data")
+	outside := filepath.Join(root, "etc")
+	for _, dir := range []string{allowed, outside, filepath.Join(allowed, "app")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(allowed, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(allowed, "app"), filepath.Join(root, "link-to-app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(allowed, filepath.Join(root, "data-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		allowed []string
+		ok      bool
+	}{
+		{name: "allowed path", source: allowed, allowed: []string{allowed}, ok: true},
+		{name: "below an allowed path", source: filepath.Join(allowed, "app"), allowed: []string{allowed}, ok: true},
+		{name: "outside", source: outside, allowed: []string{allowed}},
+		{name: "dot dot", source: filepath.Join(allowed, "..", "etc"), allowed: []string{allowed}},
+		{name: "relative", source: "data", allowed: []string{allowed}},
+		{name: "link out of an allowed path", source: filepath.Join(allowed, "escape"), allowed: []string{allowed}},
+		{name: "link into an allowed path", source: filepath.Join(root, "link-to-app"), allowed: []string{allowed}, ok: true},
+		{name: "allowed path is a link", source: filepath.Join(allowed, "app"), allowed: []string{filepath.Join(root, "data-link")}, ok: true},
+		{name: "missing path", source: filepath.Join(allowed, "missing"), allowed: []string{allowed}},
+		{name: "nothing allowed", source: allowed},
+	}
+	for _, tt := range tests {
+		err := CheckBindMounts([]Mount{{Type: MountBind, Source: tt.source, Target: "/data"}}, tt.allowed)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error = %v, want allowed %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Probe types.
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeExec = "exec"
+)
+
+// States of a probe. A probe starts out as ProbeUnknown until it reached one of
+// its thresholds.
+const (
+	ProbeUnknown = ""
+	ProbePassing = "Passing"
+	ProbeFailing = "Failing"
+)
+
+const (
+	defaultProbePeriodSeconds    = 10
+	defaultProbeTimeoutSeconds   = 1
+	defaultProbeFailureThreshold = 3
+)
+
+/*
+  - Probe is a check run by the worker next to the task. An "http" probe GETs Path on Port
+    and passes on a 2xx or 3xx status, a "tcp" probe passes when it can connect to Port, an "exec"
+    probe runs Command inside the task and passes when it exits with code 0.
+
+  - Port is the container port. The worker reaches it through the port it is published on, or
+    directly for tasks in host network mode; 0 picks the lowest published port.
+
+  - The probe runs every PeriodSeconds (10 when 0) once InitialDelaySeconds have passed since the
+    task started, each attempt limited to TimeoutSeconds (1 when 0). The probe is passing after
+    SuccessThreshold (1) passes in a row and failing after FailureThreshold (3) failures in a row.
+*/
+type Probe struct {
+	Type                string
+	Path                string
+	Port                int
+	Command             []string
+	InitialDelaySeconds int64
+	PeriodSeconds       int64
+	TimeoutSeconds      int64
+	SuccessThreshold    int
+	FailureThreshold    int
+}
+
+// ProbeStatus is the outcome of a task's probe so far, reported by the worker
+// with the task.
+type ProbeStatus struct {
+	Status               string
+	ConsecutiveSuccesses int
+	ConsecutiveFailures  int
+	LastProbeTime        time.Time
+	Message              string
+}
+
+// LivenessCheck returns the task's liveness probe. A task with only the older
+// HealthCheck path gets an HTTP probe on its lowest published port.
+func (t Task) LivenessCheck() *Probe {
+	if t.LivenessProbe != nil {
+		return t.LivenessProbe
+	}
+	if t.HealthCheck != "" {
+		return &Probe{Type: ProbeHTTP, Path: t.HealthCheck}
+	}
+	return nil
+}
+
+// UpdateReady sets the task's Ready condition: a running task is ready unless it
+// has a readiness probe that is not passing yet.
+func (t *Task) UpdateReady() {
+	t.Ready = t.State == Running && (t.ReadinessProbe == nil || t.Readiness.Status == ProbePassing)
+}
+
+// WithDefaults returns the probe with its zero values replaced by the defaults.
+func (p Probe) WithDefaults() Probe {
+	if p.PeriodSeconds == 0 {
+		p.PeriodSeconds = defaultProbePeriodSeconds
+	}
+	if p.TimeoutSeconds == 0 {
+		p.TimeoutSeconds = defaultProbeTimeoutSeconds
+	}
+	if p.SuccessThreshold == 0 {
+		p.SuccessThreshold = 1
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = defaultProbeFailureThreshold
+	}
+	return p
+}
+
+func (p Probe) Validate() error {
+	switch p.Type {
+	case ProbeHTTP, ProbeTCP:
+		if p.Port < 0 || p.Port > 65535 {
+			return fmt.Errorf("invalid probe port %d", p.Port)
+		}
+	case ProbeExec:
+		if len(p.Command) == 0 {
+			return errors.New("exec probe has no command")
+		}
+	default:
+		return fmt.Errorf("unknown probe type %q", p.Type)
+	}
+	if p.InitialDelaySeconds < 0 || p.PeriodSeconds < 0 || p.TimeoutSeconds < 0 ||
+		p.SuccessThreshold < 0 || p.FailureThreshold < 0 {
+		return errors.New("probe settings must not be negative")
+	}
+	return nil
+}
+
+// Due tells whether the probe should run at now given the status of its last run.
+func (p Probe) Due(t Task, status ProbeStatus, now time.Time) bool {
+	p = p.WithDefaults()
+	if now.Before(t.StartTime.Add(time.Duration(p.InitialDelaySeconds) * time.Second)) {
+		return false
+	}
+	return now.Sub(status.LastProbeTime) >= time.Duration(p.PeriodSeconds)*time.Second
+}
+
+// Check runs the probe once against the task. Exec probes need a runtime that
+// implements CommandRunner.
+func (p Probe) Check(t Task, r Runtime) error {
+	p = p.WithDefaults()
+	timeout := time.Duration(p.TimeoutSeconds) * time.Second
+	return runHandler(t, r, p.Type, p.Path, p.Port, p.Command, timeout)
+}
+
+// runHandler sends a request to the task the way probes and hooks do: an HTTP
+// GET that has to return a 2xx or 3xx status, a TCP connection, or a command run
+// inside the task that has to exit with code 0.
+func runHandler(t Task, r Runtime, typ string, path string, port int, cmd []string, timeout time.Duration) error {
+	switch typ {
+	case ProbeHTTP:
+		addr, err := probeAddress(t, port)
+		if err != nil {
+			return err
+		}
+		client := http.Client{Timeout: timeout}
+		resp, err := client.Get(fmt.Sprintf("http://%s%s", addr, path))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("GET %s returned %d", path, resp.StatusCode)
+		}
+		return nil
+	case ProbeTCP:
+		addr, err := probeAddress(t, port)
+		if err != nil {
+			return err
+		}
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeExec:
+		c, ok := r.(CommandRunner)
+		if !ok {
+			return fmt.Errorf("driver %q cannot run commands in a task", t.DriverName())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		code, err := c.RunCommand(ctx, t, cmd)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("%v exited with code %d", cmd, code)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown handler type %q", typ)
+}
+
+// Record updates the status with the outcome of a probe run.
+func (s *ProbeStatus) Record(p Probe, err error, now time.Time) {
+	p = p.WithDefaults()
+	s.LastProbeTime = now
+	if err == nil {
+		s.ConsecutiveSuccesses++
+		s.ConsecutiveFailures = 0
+		s.Message = ""
+		if s.ConsecutiveSuccesses >= p.SuccessThreshold {
+			s.Status = ProbePassing
+		}
+		return
+	}
+	s.ConsecutiveFailures++
+	s.ConsecutiveSuccesses = 0
+	s.Message = err.Error()
+	if s.ConsecutiveFailures >= p.FailureThreshold {
+		s.Status = ProbeFailing
+	}
+}
+
+// probeAddress is where the worker reaches a container port of the task. Port 0
+// is the lowest published container port, so the probe keeps going to the same
+// one.
+func probeAddress(t Task, port int) (string, error) {
+	if t.NetworkMode.IsHost() && port != 0 {
+		return net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil
+	}
+	var published []nat.Port
+	for p, bindings := range t.HostPorts {
+		if (port == 0 || p.Int() == port) && len(bindings) > 0 {
+			published = append(published, p)
+		}
+	}
+	if len(published) == 0 {
+		return "", fmt.Errorf("port %d of task %s is not published", port, t.ID)
+	}
+	sort.Slice(published, func(i, j int) bool {
+		if published[i].Int() != published[j].Int() {
+			return published[i].Int() < published[j].Int()
+		}
+		return published[i].Proto() < published[j].Proto()
+	})
+	return net.JoinHostPort("127.0.0.1", t.HostPorts[published[0]][0].HostPort), nil
+}
@@ -0,0 +1,37 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestProbeAddress(t *testing.T) {
+	hostPorts := nat.PortMap{
+		"9090/tcp": []nat.PortBinding{{HostPort: "32003"}},
+		"443/tcp":  []nat.PortBinding{{HostPort: "32002"}},
+		"80/udp":   []nat.PortBinding{{HostPort: "32001"}},
+		"80/tcp":   []nat.PortBinding{{HostPort: "32000"}},
+		"22/tcp":   nil,
+	}
+	tests := []struct {
+		name string
+		port int
+		want string
+		ok   bool
+	}{
+		{name: "lowest published port", port: 0, want: "127.0.0.1:32000", ok: true},
+		{name: "given port", port: 443, want: "127.0.0.1:32002", ok: true},
+		{name: "unpublished port", port: 22},
+		{name: "unknown port", port: 8080},
+	}
+	for _, tt := range tests {
+		// the map is walked in a different order on every run
+		for i := 0; i < 20; i++ {
+			got, err := probeAddress(Task{HostPorts: hostPorts}, tt.port)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Fatalf("%s: probeAddress = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		}
+	}
+}
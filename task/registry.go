@@ -0,0 +1,96 @@
+package task
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"strings"
+	"time"
+)
+
+// Image pull policies. An empty policy behaves like PullAlways.
+const (
+	PullAlways       = "Always"
+	PullIfNotPresent = "IfNotPresent"
+	PullNever        = "Never"
+)
+
+const DefaultRegistry = "docker.io"
+
+// RegistryAuth holds the credentials used to pull images from a private registry.
+type RegistryAuth struct {
+	Server   string
+	Username string
+	Password string
+}
+
+// RegistryCredentials are a registry's credentials as the manager stores them, the password
+// encrypted with the secrets key like the values of secrets.
+type RegistryCredentials struct {
+	Server       string
+	Username     string
+	Ciphertext   []byte
+	CreationTime time.Time
+}
+
+// Encode returns the credentials in the base64 form the docker API expects.
+func (a RegistryAuth) Encode() (string, error) {
+	buf, err := json.Marshal(types.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		ServerAddress: a.Server,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+// Registry returns the registry host an image is pulled from, following docker's
+// rule that the first path component is a registry only if it looks like a host.
+func Registry(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return DefaultRegistry
+	}
+	host := image[:i]
+	if host != "localhost" && !strings.ContainsAny(host, ".:") {
+		return DefaultRegistry
+	}
+	return host
+}
+
+func ValidPullPolicy(policy string) bool {
+	switch policy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+		return true
+	}
+	return false
+}
+
+// ValidatePullPolicies checks the pull policy of the task and of every container
+// of its group.
+func ValidatePullPolicies(t Task) error {
+	if !ValidPullPolicy(t.ImagePullPolicy) {
+		return fmt.Errorf("unknown image pull policy %q", t.ImagePullPolicy)
+	}
+	for _, c := range t.Containers {
+		if !ValidPullPolicy(c.ImagePullPolicy) {
+			return fmt.Errorf("unknown image pull policy %q for container %s", c.ImagePullPolicy, c.Name)
+		}
+	}
+	return nil
+}
+
+// AuthFor returns the credentials for the registry image is pulled from, if
+// auths has them.
+func AuthFor(auths []RegistryAuth, image string) (RegistryAuth, bool) {
+	registry := Registry(image)
+	for _, a := range auths {
+		if a.Server == registry {
+			return a, true
+		}
+	}
+	return RegistryAuth{}, false
+}
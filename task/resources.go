@@ -0,0 +1,58 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capabilities tells which of a task's resource limits a driver on a worker can
+// enforce. A task asking for a limit its driver cannot enforce is rejected
+// instead of silently running without it.
+type Capabilities struct {
+	Cpu     bool
+	Memory  bool
+	Disk    bool
+	Pids    bool
+	Cpuset  bool
+	Swap    bool
+	Ulimits bool
+}
+
+// CapabilityReporter is implemented by drivers that know which limits they
+// enforce. An error means the driver cannot tell right now.
+type CapabilityReporter interface {
+	Capabilities() (Capabilities, error)
+}
+
+// Check returns an error naming every limit requested by the task that cannot be enforced.
+// The limits of a task group's extra containers count as well.
+func (c Capabilities) Check(t Task) error {
+	var missing []string
+	r := t.Resources()
+	if r.Cpu > 0 && !c.Cpu {
+		missing = append(missing, "Cpu")
+	}
+	if r.Memory > 0 && !c.Memory {
+		missing = append(missing, "Memory")
+	}
+	if r.Disk > 0 && !c.Disk {
+		missing = append(missing, "Disk")
+	}
+	if t.PidsLimit > 0 && !c.Pids {
+		missing = append(missing, "PidsLimit")
+	}
+	if t.CpusetCpus != "" && !c.Cpuset {
+		missing = append(missing, "CpusetCpus")
+	}
+	if t.MemorySwap != 0 && !c.Swap {
+		missing = append(missing, "MemorySwap")
+	}
+	if len(t.Ulimits) > 0 && !c.Ulimits {
+		missing = append(missing, "Ulimits")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the %s driver cannot enforce %s", t.DriverName(), strings.Join(missing, ", "))
+	}
+	return nil
+}
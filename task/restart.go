@@ -0,0 +1,133 @@
+package task
+
+import (
+	"fmt"
+	"time"
+)
+
+// Restart policies, applied by the manager. An empty policy behaves like
+// RestartOnFailure.
+const (
+	RestartNever     = "Never"
+	RestartOnFailure = "OnFailure"
+	RestartAlways    = "Always"
+)
+
+const (
+	DefaultRestartMaxRetries = 3
+	defaultRestartBackoff    = 10 * time.Second
+	defaultRestartMaxBackoff = 5 * time.Minute
+	defaultRestartReset      = 10 * time.Minute
+)
+
+/*
+  - MaxRetries is how many times in a row the task is restarted, 3 when not set;
+    0 means the task is never restarted.
+
+  - The n-th restart in a row waits BackoffSeconds (10 when 0) doubled n-1 times, at most
+    MaxBackoffSeconds (300 when 0), counted from when the task ended.
+
+  - A task that ran for ResetSeconds (600 when 0) before it ended starts counting its restarts
+    from 0 again, so one failure a day does not use up the retries.
+
+  - Reschedule restarts the task on a worker picked by the scheduler, a different one
+    than it ran on when there is another candidate.
+*/
+type RestartConfig struct {
+	MaxRetries        *int
+	BackoffSeconds    int64
+	MaxBackoffSeconds int64
+	ResetSeconds      int64
+	Reschedule        bool
+}
+
+func ValidRestartPolicy(p string) bool {
+	switch p {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return true
+	}
+	return false
+}
+
+func (c RestartConfig) Validate() error {
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		return fmt.Errorf("MaxRetries must not be negative")
+	}
+	if c.BackoffSeconds < 0 || c.MaxBackoffSeconds < 0 || c.ResetSeconds < 0 {
+		return fmt.Errorf("restart durations must not be negative")
+	}
+	return nil
+}
+
+// Backoff returns how long to wait before the restart that follows the given
+// number of restarts in a row.
+func (c RestartConfig) Backoff(restarts int) time.Duration {
+	backoff, max := defaultRestartBackoff, defaultRestartMaxBackoff
+	if c.BackoffSeconds > 0 {
+		backoff = time.Duration(c.BackoffSeconds) * time.Second
+	}
+	if c.MaxBackoffSeconds > 0 {
+		max = time.Duration(c.MaxBackoffSeconds) * time.Second
+	}
+	for i := 0; i < restarts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// WantsRestart tells whether the task's restart policy asks for it to be
+// restarted in its current state, leaving retries and backoff aside. A task
+// that was stopped is never restarted.
+func (t *Task) WantsRestart() bool {
+	if t.RestartPolicy == RestartNever {
+		return false
+	}
+	switch t.State {
+	case Failed:
+		return true
+	case Running:
+		return t.Liveness.Status == ProbeFailing
+	case Completed:
+		tr := t.LastTransition()
+		return t.RestartPolicy == RestartAlways && tr != nil && tr.Reason == ReasonExited
+	}
+	return false
+}
+
+// NextRestart returns when the task is to be restarted, or false if its policy
+// does not restart it (anymore). A task that ran for the reset window before it
+// ended gets its RestartCount set back to 0.
+func (t *Task) NextRestart() (time.Time, bool) {
+	if !t.WantsRestart() {
+		return time.Time{}, false
+	}
+
+	// a failed or completed task ended when it got into that state
+	ended := time.Now()
+	if tr := t.LastTransition(); tr != nil && t.State != Running {
+		ended = tr.Timestamp
+	}
+	reset := defaultRestartReset
+	if t.Restart.ResetSeconds > 0 {
+		reset = time.Duration(t.Restart.ResetSeconds) * time.Second
+	}
+	if !t.StartTime.IsZero() && ended.Sub(t.StartTime) >= reset {
+		t.RestartCount = 0
+	}
+
+	max := DefaultRestartMaxRetries
+	if t.Restart.MaxRetries != nil {
+		max = *t.Restart.MaxRetries
+	}
+	if t.RestartCount >= max {
+		return time.Time{}, false
+	}
+	if t.State == Running {
+		// an unhealthy task is replaced right away, the backoff is for tasks that ended
+		return ended, true
+	}
+	return ended.Add(t.Restart.Backoff(t.RestartCount)), true
+}
@@ -0,0 +1,169 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		cfg      RestartConfig
+		restarts int
+		want     time.Duration
+	}{
+		{restarts: 0, want: 10 * time.Second},
+		{restarts: 1, want: 20 * time.Second},
+		{restarts: 3, want: 80 * time.Second},
+		{restarts: 5, want: 5 * time.Minute},
+		{restarts: 100, want: 5 * time.Minute},
+		{cfg: RestartConfig{BackoffSeconds: 1}, restarts: 2, want: 4 * time.Second},
+		{cfg: RestartConfig{BackoffSeconds: 1, MaxBackoffSeconds: 3}, restarts: 2, want: 3 * time.Second},
+		{cfg: RestartConfig{BackoffSeconds: 60, MaxBackoffSeconds: 30}, restarts: 0, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Backoff(tt.restarts); got != tt.want {
+			t.Errorf("%+v after %d restarts: got %v, want %v", tt.cfg, tt.restarts, got, tt.want)
+		}
+	}
+}
+
+func TestNextRestart(t *testing.T) {
+	ended := time.Now().Add(-time.Minute)
+	zero := 0
+	endedTask := func(state State, reason string, ran time.Duration) Task {
+		return Task{
+			State:     state,
+			StartTime: ended.Add(-ran),
+			Transitions: []Transition{
+				{From: Running, To: state, Reason: reason, Timestamp: ended},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		task      Task
+		restart   bool
+		at        time.Time
+		wantCount int
+	}{
+		{
+			name:    "first failure",
+			task:    endedTask(Failed, ReasonExited, 30*time.Second),
+			restart: true,
+			at:      ended.Add(10 * time.Second),
+		},
+		{
+			name: "third failure in a row",
+			task: func() Task {
+				tk := endedTask(Failed, ReasonExited, 30*time.Second)
+				tk.RestartCount = 2
+				return tk
+			}(),
+			restart:   true,
+			at:        ended.Add(40 * time.Second),
+			wantCount: 2,
+		},
+		{
+			name: "out of retries",
+			task: func() Task {
+				tk := endedTask(Failed, ReasonExited, 30*time.Second)
+				tk.RestartCount = DefaultRestartMaxRetries
+				return tk
+			}(),
+			wantCount: DefaultRestartMaxRetries,
+		},
+		{
+			name: "ran past the reset window",
+			task: func() Task {
+				tk := endedTask(Failed, ReasonExited, 11*time.Minute)
+				tk.RestartCount = DefaultRestartMaxRetries
+				return tk
+			}(),
+			restart: true,
+			at:      ended.Add(10 * time.Second),
+		},
+		{
+			name: "custom reset window",
+			task: func() Task {
+				tk := endedTask(Failed, ReasonExited, 2*time.Minute)
+				tk.RestartCount = 1
+				tk.Restart.ResetSeconds = 60
+				return tk
+			}(),
+			restart: true,
+			at:      ended.Add(10 * time.Second),
+		},
+		{
+			name: "no retries",
+			task: func() Task {
+				tk := endedTask(Failed, ReasonExited, 30*time.Second)
+				tk.Restart.MaxRetries = &zero
+				return tk
+			}(),
+		},
+		{
+			name: "policy Never",
+			task: func() Task {
+				tk := endedTask(Failed, ReasonExited, 30*time.Second)
+				tk.RestartPolicy = RestartNever
+				return tk
+			}(),
+		},
+		{
+			name: "completed with OnFailure",
+			task: endedTask(Completed, ReasonExited, 30*time.Second),
+		},
+		{
+			name: "completed with Always",
+			task: func() Task {
+				tk := endedTask(Completed, ReasonExited, 30*time.Second)
+				tk.RestartPolicy = RestartAlways
+				return tk
+			}(),
+			restart: true,
+			at:      ended.Add(10 * time.Second),
+		},
+		{
+			name: "stopped with Always",
+			task: func() Task {
+				tk := endedTask(Completed, ReasonStopped, 30*time.Second)
+				tk.RestartPolicy = RestartAlways
+				return tk
+			}(),
+		},
+		{
+			name: "running and healthy",
+			task: endedTask(Running, ReasonStarted, 30*time.Second),
+		},
+	}
+	for _, tt := range tests {
+		at, ok := tt.task.NextRestart()
+		if ok != tt.restart {
+			t.Errorf("%s: restart = %v, want %v", tt.name, ok, tt.restart)
+			continue
+		}
+		if ok && !at.Equal(tt.at) {
+			t.Errorf("%s: restart at %v, want %v", tt.name, at, tt.at)
+		}
+		if tt.task.RestartCount != tt.wantCount {
+			t.Errorf("%s: RestartCount = %d, want %d", tt.name, tt.task.RestartCount, tt.wantCount)
+		}
+	}
+}
+
+func TestNextRestartUnhealthyRunningTask(t *testing.T) {
+	tk := Task{
+		State:     Running,
+		StartTime: time.Now().Add(-time.Minute),
+		Liveness:  ProbeStatus{Status: ProbeFailing},
+	}
+	before := time.Now()
+	at, ok := tk.NextRestart()
+	if !ok {
+		t.Fatal("a task failing its liveness probe is not restarted")
+	}
+	if at.Before(before) || at.After(time.Now()) {
+		t.Errorf("restart at %v, want right away", at)
+	}
+}
@@ -0,0 +1,84 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Balancers spreading the requests of a route over its tasks.
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceLeastConnections = "least-connections"
+)
+
+/*
+  - Route sends the requests the manager's proxy receives for Host, or for paths starting with
+    PathPrefix, to the tasks named TaskName or carrying all of Labels, e.g. {"cube.service": "echo"}
+    for the tasks of a service. With both Host and PathPrefix set a request has to match both.
+
+  - Port is the container port requests go to, the task's first published port when 0. StripPrefix
+    removes PathPrefix from the path before the request is passed on.
+
+  - Balancer is "round-robin" (the default) or "least-connections". Only running tasks that are
+    ready and not failing their liveness probe get requests.
+*/
+type Route struct {
+	Name        string
+	Host        string
+	PathPrefix  string
+	StripPrefix bool
+	TaskName    string
+	Labels      map[string]string
+	Port        int
+	Balancer    string
+}
+
+func (r *Route) Validate() error {
+	if len(r.Name) > 63 || !serviceNameRe.MatchString(r.Name) {
+		return fmt.Errorf("route name %q must be lower case letters, digits and dashes, at most 63 characters", r.Name)
+	}
+	if r.Host == "" && r.PathPrefix == "" {
+		return fmt.Errorf("route %s matches neither a host nor a path prefix", r.Name)
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path prefix %q of route %s does not start with /", r.PathPrefix, r.Name)
+	}
+	if r.TaskName == "" && len(r.Labels) == 0 {
+		return fmt.Errorf("route %s selects no tasks, it needs a task name or labels", r.Name)
+	}
+	if r.Port < 0 || r.Port > 65535 {
+		return fmt.Errorf("invalid port %d of route %s", r.Port, r.Name)
+	}
+	switch r.Balancer {
+	case "", BalanceRoundRobin, BalanceLeastConnections:
+	default:
+		return fmt.Errorf("unknown balancer %q of route %s", r.Balancer, r.Name)
+	}
+	return nil
+}
+
+// Selects tells whether the task is one the route sends requests to.
+func (r *Route) Selects(t *Task) bool {
+	if r.TaskName != "" && t.Name != r.TaskName {
+		return false
+	}
+	for k, v := range r.Labels {
+		if t.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches tells whether the route takes a request for host and path; host has no
+// port and is lower case.
+func (r *Route) Matches(host string, path string) bool {
+	if r.Host != "" && !strings.EqualFold(r.Host, host) {
+		return false
+	}
+	if r.PathPrefix == "" || r.PathPrefix == "/" {
+		return true
+	}
+	prefix := strings.TrimSuffix(r.PathPrefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
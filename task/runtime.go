@@ -0,0 +1,333 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Runtime is the driver a worker uses to run its tasks. The worker only talks to
+// this interface, so a new driver can be added without touching the worker loop.
+type Runtime interface {
+	Run(t Task) DockerResult
+	Stop(t Task) DockerResult
+	Inspect(t Task) InspectResponse
+	Logs(t Task, opts LogOptions) (io.ReadCloser, error)
+	Wait(t Task) InspectResponse
+}
+
+// Executor is implemented by drivers that can run an extra command inside a
+// running task, e.g. a shell for debugging.
+type Executor interface {
+	Exec(t Task, opts ExecOptions) (ExecSession, error)
+}
+
+// ExecOptions.Container names the container of a task group to run the command
+// in, the main one when empty. Height and Width give the size of the tty in
+// rows and columns; zero leaves the engine's default.
+type ExecOptions struct {
+	Cmd       []string
+	Tty       bool
+	Stdin     bool
+	Container string
+	Height    uint
+	Width     uint
+}
+
+// ExecSession is the attached stdin/stdout/stderr of a command started by Exec.
+// Without a tty, stdout and stderr are multiplexed the way docker does it (see
+// stdcopy); CloseWrite signals the end of stdin.
+type ExecSession interface {
+	io.ReadWriteCloser
+	CloseWrite() error
+}
+
+// CommandRunner is implemented by drivers that can run a command inside a task
+// and report its exit code, as exec probes do.
+type CommandRunner interface {
+	RunCommand(ctx context.Context, t Task, cmd []string) (int, error)
+}
+
+// GroupStopper is implemented by drivers that run task groups. StopGroup stops
+// what is left of a group once one of its containers made it exit.
+type GroupStopper interface {
+	StopGroup(t Task) error
+}
+
+// ContainerState is the driver independent view of a task's container (or process).
+type ContainerState struct {
+	Status     string
+	Running    bool
+	ExitCode   int
+	OOMKilled  bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	HostPorts  nat.PortMap
+}
+
+type InspectResponse struct {
+	Error error
+	State *ContainerState
+	// Container holds the raw docker inspect response; it is nil for other drivers.
+	Container *types.ContainerJSON
+}
+
+// LogOptions select which part of a task's output Runtime.Logs returns.
+// Tail is a number of lines or "all"; Since is a timestamp or a relative duration
+// such as "10m" and is only honoured by the docker driver. Container names the
+// container of a task group to read, the main one when empty.
+type LogOptions struct {
+	Follow    bool
+	Tail      string
+	Since     string
+	Container string
+}
+
+// Drivers is a Runtime that hands each task to the driver named by its Driver
+// field, falling back to "docker" when the task does not name one.
+type Drivers map[string]Runtime
+
+func (d Drivers) runtime(t Task) (Runtime, error) {
+	name := t.DriverName()
+	r, ok := d[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %q for task %s", name, t.ID)
+	}
+	return r, nil
+}
+
+func (d Drivers) Run(t Task) DockerResult {
+	r, err := d.runtime(t)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+	return r.Run(t)
+}
+
+func (d Drivers) Stop(t Task) DockerResult {
+	r, err := d.runtime(t)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+	return r.Stop(t)
+}
+
+func (d Drivers) Inspect(t Task) InspectResponse {
+	r, err := d.runtime(t)
+	if err != nil {
+		return InspectResponse{Error: err}
+	}
+	return r.Inspect(t)
+}
+
+func (d Drivers) Logs(t Task, opts LogOptions) (io.ReadCloser, error) {
+	r, err := d.runtime(t)
+	if err != nil {
+		return nil, err
+	}
+	return r.Logs(t, opts)
+}
+
+func (d Drivers) Wait(t Task) InspectResponse {
+	r, err := d.runtime(t)
+	if err != nil {
+		return InspectResponse{Error: err}
+	}
+	return r.Wait(t)
+}
+
+func (d Drivers) Exec(t Task, opts ExecOptions) (ExecSession, error) {
+	r, err := d.runtime(t)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := r.(Executor)
+	if !ok {
+		return nil, fmt.Errorf("driver %q does not support exec", t.DriverName())
+	}
+	return e.Exec(t, opts)
+}
+
+func (d Drivers) RunCommand(ctx context.Context, t Task, cmd []string) (int, error) {
+	r, err := d.runtime(t)
+	if err != nil {
+		return 0, err
+	}
+	c, ok := r.(CommandRunner)
+	if !ok {
+		return 0, fmt.Errorf("driver %q cannot run commands in a task", t.DriverName())
+	}
+	return c.RunCommand(ctx, t, cmd)
+}
+
+func (d Drivers) StopGroup(t Task) error {
+	r, err := d.runtime(t)
+	if err != nil {
+		return err
+	}
+	g, ok := r.(GroupStopper)
+	if !ok {
+		return fmt.Errorf("driver %q does not support task groups", t.DriverName())
+	}
+	return g.StopGroup(t)
+}
+
+type DockerRuntime struct {
+	Client *client.Client
+	mu     sync.Mutex
+	caps   *Capabilities
+}
+
+func NewDockerRuntime() (*DockerRuntime, error) {
+	dc, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, fmt.Errorf("creating docker client: %v", err)
+	}
+	return &DockerRuntime{Client: dc}, nil
+}
+
+func (r *DockerRuntime) docker(t Task) *Docker {
+	d := Docker{
+		Client: r.Client,
+		Config: *NewConfig(&t),
+	}
+
+	if auth, ok := AuthFor(t.RegistryAuths, t.Image); ok {
+		encoded, err := auth.Encode()
+		if err != nil {
+			log.Printf("Error encoding credentials for registry %s: %v\n", auth.Server, err)
+		}
+		d.RegistryAuth = encoded
+	}
+	return &d
+}
+
+func (r *DockerRuntime) Run(t Task) DockerResult {
+	d := r.docker(t)
+	result := d.Run()
+	if result.Error != nil || len(t.Containers) == 0 {
+		return result
+	}
+
+	ids, err := r.runGroup(t, result.ContainerId)
+	if err != nil {
+		d.Stop(result.ContainerId)
+		return DockerResult{Error: err}
+	}
+	result.GroupContainerIds = ids
+	return result
+}
+
+// Stop stops the main container and those of its group all at once, so they
+// share one grace period, then removes them.
+func (r *DockerRuntime) Stop(t Task) DockerResult {
+	r.signalGroup(t)
+	r.stopGroup(t)
+	return r.docker(t).Stop(t.ContainerID)
+}
+
+func (r *DockerRuntime) Inspect(t Task) InspectResponse {
+	resp := r.docker(t).Inspect(t.ContainerID)
+	if resp.Error != nil {
+		return InspectResponse{Error: resp.Error}
+	}
+	state := containerState(resp)
+	r.groupState(t, state)
+	return InspectResponse{
+		State:     state,
+		Container: resp.Container,
+	}
+}
+
+func (r *DockerRuntime) Logs(t Task, opts LogOptions) (io.ReadCloser, error) {
+	id, err := t.ContainerIDOf(opts.Container)
+	if err != nil {
+		return nil, err
+	}
+	return r.docker(t).Logs(id, opts)
+}
+
+func (r *DockerRuntime) Wait(t Task) InspectResponse {
+	d := r.docker(t)
+	if err := d.Wait(t.ContainerID); err != nil {
+		return InspectResponse{Error: err}
+	}
+	return r.Inspect(t)
+}
+
+func (r *DockerRuntime) Exec(t Task, opts ExecOptions) (ExecSession, error) {
+	id, err := t.ContainerIDOf(opts.Container)
+	if err != nil {
+		return nil, err
+	}
+	return r.docker(t).Exec(id, opts)
+}
+
+func (r *DockerRuntime) RunCommand(ctx context.Context, t Task, cmd []string) (int, error) {
+	return r.docker(t).RunCommand(ctx, t.ContainerID, cmd)
+}
+
+// Capabilities asks the docker daemon which limits it supports. A disk size can
+// only be enforced by storage drivers with quota support; for overlay2 that
+// needs an xfs backing filesystem (mounted with pquota, which is not checked).
+// The answer is cached once the daemon gave one; until then every call asks again.
+func (r *DockerRuntime) Capabilities() (Capabilities, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.caps != nil {
+		return *r.caps, nil
+	}
+
+	info, err := r.Client.Info(context.Background())
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("getting docker info: %v", err)
+	}
+
+	disk := false
+	switch info.Driver {
+	case "btrfs", "zfs", "devicemapper", "windowsfilter":
+		disk = true
+	case "overlay2":
+		for _, s := range info.DriverStatus {
+			if s[0] == "Backing Filesystem" && s[1] == "xfs" {
+				disk = true
+			}
+		}
+	}
+
+	r.caps = &Capabilities{
+		Cpu:     info.CPUCfsQuota,
+		Memory:  info.MemoryLimit,
+		Disk:    disk,
+		Pids:    info.PidsLimit,
+		Cpuset:  info.CPUSet,
+		Swap:    info.SwapLimit,
+		Ulimits: true,
+	}
+	return *r.caps, nil
+}
+
+func containerState(resp DockerInspectResponse) *ContainerState {
+	c := resp.Container
+	if c == nil || c.ContainerJSONBase == nil || c.State == nil {
+		return nil
+	}
+	s := ContainerState{
+		Status:    c.State.Status,
+		Running:   c.State.Running,
+		ExitCode:  c.State.ExitCode,
+		OOMKilled: c.State.OOMKilled,
+	}
+	s.StartedAt, _ = time.Parse(time.RFC3339Nano, c.State.StartedAt)
+	s.FinishedAt, _ = time.Parse(time.RFC3339Nano, c.State.FinishedAt)
+	if c.NetworkSettings != nil {
+		s.HostPorts = c.NetworkSettings.NetworkSettingsBase.Ports
+	}
+	return &s
+}
@@ -0,0 +1,155 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+func TestDockerCapabilitiesRetryUntilDaemonAnswers(t *testing.T) {
+	// the first call fails, as while the daemon is starting
+	var calls int32
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/info") {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "daemon starting", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(types.Info{
+			Driver:       "overlay2",
+			DriverStatus: [][2]string{{"Backing Filesystem", "xfs"}},
+			MemoryLimit:  true,
+			CPUCfsQuota:  true,
+		})
+	}))
+	defer daemon.Close()
+
+	dc, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(daemon.URL, "http://")), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &DockerRuntime{Client: dc}
+
+	if _, err := r.Capabilities(); err == nil {
+		t.Fatal("no error while the daemon fails")
+	}
+	caps, err := r.Capabilities()
+	if err != nil {
+		t.Fatalf("second call did not ask the daemon again: %v", err)
+	}
+	want := Capabilities{Cpu: true, Memory: true, Disk: true, Ulimits: true}
+	if caps != want {
+		t.Errorf("capabilities = %+v, want %+v", caps, want)
+	}
+
+	// the answer is cached
+	r.Capabilities()
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("the daemon was asked %d times, want 2", n)
+	}
+}
+
+func TestDockerExecResizesTty(t *testing.T) {
+	var mu sync.Mutex
+	var resized string
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/c1/exec"):
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(types.IDResponse{ID: "e1"})
+		case strings.HasSuffix(r.URL.Path, "/exec/e1/start"):
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n"))
+			conn.Close()
+		case strings.HasSuffix(r.URL.Path, "/exec/e1/resize"):
+			mu.Lock()
+			resized = r.URL.Query().Get("h") + "x" + r.URL.Query().Get("w")
+			mu.Unlock()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer daemon.Close()
+
+	dc, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(daemon.URL, "http://")), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Docker{Client: dc}
+
+	tests := []struct {
+		name string
+		opts ExecOptions
+		want string
+	}{
+		{name: "tty", opts: ExecOptions{Cmd: []string{"sh"}, Tty: true, Height: 40, Width: 120}, want: "40x120"},
+		{name: "tty without a size", opts: ExecOptions{Cmd: []string{"sh"}, Tty: true}},
+		{name: "no tty", opts: ExecOptions{Cmd: []string{"ls"}, Height: 40, Width: 120}},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		resized = ""
+		mu.Unlock()
+		session, err := d.Exec("c1", tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		session.Close()
+		mu.Lock()
+		if resized != tt.want {
+			t.Errorf("%s: tty resized to %q, want %q", tt.name, resized, tt.want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestDockerRunCommandAbandonsCommandAfterTimeout(t *testing.T) {
+	// the command never finishes
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/c1/exec"):
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(types.IDResponse{ID: "e1"})
+		case strings.HasSuffix(r.URL.Path, "/exec/e1/start"):
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/exec/e1/json"):
+			json.NewEncoder(w).Encode(types.ContainerExecInspect{ExecID: "e1", Running: true, Pid: 1})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer daemon.Close()
+
+	dc, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(daemon.URL, "http://")), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Docker{Client: dc}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.RunCommand(ctx, "c1", []string{"sleep", "infinity"}); err == nil {
+		t.Fatal("no error for a command that did not finish")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RunCommand returned after %v, want it to give up when ctx is done", elapsed)
+	}
+}
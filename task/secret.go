@@ -0,0 +1,122 @@
+package task
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SecretsKeyEnv is the environment variable holding the secrets key when no key
+// file is given.
+const SecretsKeyEnv = "CUBE_SECRETS_KEY"
+
+var secretNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Secret is a secret as the manager stores it, its value encrypted with the
+// secrets key. The value itself only leaves the manager in the events that start
+// a task referencing it.
+type Secret struct {
+	Name         string
+	Ciphertext   []byte
+	CreationTime time.Time
+}
+
+// SecretRef makes a secret available to a task, as the environment variable Env,
+// as a read-only file at the absolute path File backed by tmpfs on the worker, or both.
+type SecretRef struct {
+	Name string
+	Env  string
+	File string
+}
+
+func ValidSecretName(name string) bool {
+	return len(name) <= 253 && secretNameRe.MatchString(name)
+}
+
+func (r SecretRef) Validate() error {
+	if !ValidSecretName(r.Name) {
+		return fmt.Errorf("invalid secret name %q", r.Name)
+	}
+	if r.Env == "" && r.File == "" {
+		return fmt.Errorf("secret %s is neither injected as an environment variable nor as a file", r.Name)
+	}
+	if strings.Contains(r.Env, "=") {
+		return fmt.Errorf("invalid environment variable name %q for secret %s", r.Env, r.Name)
+	}
+	if r.File != "" && (!path.IsAbs(r.File) || path.Clean(r.File) == "/") {
+		return fmt.Errorf("secret file %q of secret %s is not an absolute file path", r.File, r.Name)
+	}
+	return nil
+}
+
+// LoadSecretsKey reads the 32 byte AES-256 key secrets are encrypted with, base64
+// encoded, from file or, without a file, from the CUBE_SECRETS_KEY environment variable.
+func LoadSecretsKey(file string) ([]byte, error) {
+	encoded := os.Getenv(SecretsKeyEnv)
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("no secrets key file given and %s is not set", SecretsKeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not base64 encoded: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets key has %d bytes, it needs 32", len(key))
+	}
+	return key, nil
+}
+
+// SecretCipher encrypts secret values with AES-256-GCM. The secret's name is
+// authenticated with its value, so a ciphertext cannot be moved to another name.
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretCipher{aead: aead}, nil
+}
+
+// Seal returns the value encrypted for the named secret, prefixed with its nonce.
+func (c *SecretCipher) Seal(name string, value string) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, []byte(value), []byte(name)), nil
+}
+
+func (c *SecretCipher) Open(name string, ciphertext []byte) (string, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n {
+		return "", errors.New("ciphertext too short")
+	}
+	value, err := c.aead.Open(nil, ciphertext[:n], ciphertext[n:], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt secret %s: %v", name, err)
+	}
+	return string(value), nil
+}
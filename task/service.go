@@ -0,0 +1,169 @@
+package task
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// LabelService is set on every task of a service to the service's name,
+// LabelRevision to the revision of the template the task was created from.
+const (
+	LabelService  = "cube.service"
+	LabelRevision = "cube.revision"
+)
+
+// States of a service's rolling update.
+const (
+	UpdateProgressing = "Progressing"
+	UpdatePaused      = "Paused"
+	UpdateCompleted   = "Completed"
+)
+
+// maxServiceRevisions is how many previous revisions a service keeps for rollbacks.
+const maxServiceRevisions = 10
+
+const defaultHealthCheckGraceSeconds = 30
+
+var serviceNameRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+/*
+  - Replicas is the number of tasks the manager keeps alive from Template. A task that is pending,
+    scheduled, running or restarting counts as a replica; one that is lost is replaced, and whichever
+    ends up in excess once its worker is back is stopped.
+
+  - Tasks are the tasks of the service that have not finished yet, RunningReplicas is how many
+    of them are running and ReadyReplicas how many of those are ready.
+
+  - Changing the template creates a new Revision and rolls it out following UpdateConfig; the
+    previous templates are kept in History, so a rollback restores one of them under its own revision.
+*/
+type Service struct {
+	Name            string
+	Replicas        int
+	Template        Task
+	Revision        int
+	History         []ServiceRevision
+	UpdateConfig    UpdateConfig
+	UpdateState     string
+	UpdateMessage   string
+	CreationTime    time.Time
+	Tasks           []uuid.UUID
+	RunningReplicas int
+	ReadyReplicas   int
+}
+
+type ServiceRevision struct {
+	Revision int
+	Template Task
+}
+
+/*
+  - MaxSurge is how many tasks may run above Replicas during an update, MaxUnavailable how many
+    below it. When both are 0, one extra task is started at a time.
+
+  - A task of the new revision is available once it is ready and its liveness probe passes. One that
+    fails, or whose probes still fail HealthCheckGraceSeconds (30 when 0) after starting, pauses the update.
+*/
+type UpdateConfig struct {
+	MaxSurge                int
+	MaxUnavailable          int
+	HealthCheckGraceSeconds int64
+}
+
+// WithDefaults returns the configuration with its zero values replaced by the defaults.
+func (c UpdateConfig) WithDefaults() UpdateConfig {
+	if c.MaxSurge == 0 && c.MaxUnavailable == 0 {
+		c.MaxSurge = 1
+	}
+	if c.HealthCheckGraceSeconds == 0 {
+		c.HealthCheckGraceSeconds = defaultHealthCheckGraceSeconds
+	}
+	return c
+}
+
+// Validate checks the service can be run. The name is used in DNS names, so it
+// has to be a valid DNS label.
+func (s *Service) Validate() error {
+	if len(s.Name) > 63 || !serviceNameRe.MatchString(s.Name) {
+		return fmt.Errorf("service name %q must be lower case letters, digits and dashes, at most 63 characters", s.Name)
+	}
+	if s.Replicas < 0 {
+		return fmt.Errorf("service %s has a negative replica count", s.Name)
+	}
+	if s.Template.Kind == KindBatch {
+		return fmt.Errorf("service %s has a batch task template, services run long-running tasks", s.Name)
+	}
+	c := s.UpdateConfig
+	if c.MaxSurge < 0 || c.MaxUnavailable < 0 || c.HealthCheckGraceSeconds < 0 {
+		return fmt.Errorf("service %s has a negative update setting", s.Name)
+	}
+	if err := ValidatePullPolicies(s.Template); err != nil {
+		return fmt.Errorf("service %s: %v", s.Name, err)
+	}
+	return nil
+}
+
+// Update makes template the service's new revision. The current one is kept in
+// History.
+func (s *Service) Update(template Task) {
+	next := s.Revision
+	for _, r := range s.History {
+		if r.Revision > next {
+			next = r.Revision
+		}
+	}
+	s.pushRevision()
+	s.Revision = next + 1
+	s.Template = template
+	s.UpdateState = UpdateProgressing
+	s.UpdateMessage = fmt.Sprintf("updating to revision %d", s.Revision)
+}
+
+// Rollback makes the latest revision in History the current one again; the
+// current revision goes to History, so a second rollback undoes the first.
+func (s *Service) Rollback() error {
+	if len(s.History) == 0 {
+		return fmt.Errorf("service %s has no previous revision", s.Name)
+	}
+	prev := s.History[len(s.History)-1]
+	s.History = s.History[:len(s.History)-1]
+	s.pushRevision()
+	s.Revision = prev.Revision
+	s.Template = prev.Template
+	s.UpdateState = UpdateProgressing
+	s.UpdateMessage = fmt.Sprintf("rolling back to revision %d", s.Revision)
+	return nil
+}
+
+func (s *Service) pushRevision() {
+	s.History = append(s.History, ServiceRevision{Revision: s.Revision, Template: s.Template})
+	if len(s.History) > maxServiceRevisions {
+		s.History = s.History[len(s.History)-maxServiceRevisions:]
+	}
+}
+
+// NewTask returns a new task of the service built from its template.
+func (s *Service) NewTask() Task {
+	t := s.Template
+	t.ID = uuid.New()
+	t.State = Pending
+	t.Transitions = nil
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.Labels = make(map[string]string)
+	for k, v := range s.Template.Labels {
+		t.Labels[k] = v
+	}
+	t.Labels[LabelService] = s.Name
+	t.Labels[LabelRevision] = strconv.Itoa(s.Revision)
+	return t
+}
+
+// TaskRevision returns the revision of the service template a task was created
+// from, 0 for tasks created before services had revisions.
+func TaskRevision(t *Task) int {
+	r, _ := strconv.Atoi(t.Labels[LabelRevision])
+	return r
+}
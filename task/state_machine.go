@@ -1,25 +1,96 @@
 package task
 
-type State int
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
 
-func (s State) String() []string {
-	return []string{"Pending", "Scheduled", "Running", "Completed", "Failed"}
-}
+type State int
 
+/*
+- Stopping: a stop was requested and the worker is shutting the container down.
+- Restarting: the manager asked for the task to be restarted after a failed health check.
+- Lost: the worker running the task cannot be reached; it may come back with the task.
+- Cancelled: the user stopped the task while it was still pending, it never ran.
+*/
 const (
 	Pending State = iota
 	Scheduled
 	Running
 	Completed
 	Failed
+	Stopping
+	Restarting
+	Lost
+	Cancelled
 )
 
+var stateNames = []string{"Pending", "Scheduled", "Running", "Completed", "Failed", "Stopping", "Restarting", "Lost", "Cancelled"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+func ParseState(name string) (State, error) {
+	for i, n := range stateNames {
+		if n == name {
+			return State(i), nil
+		}
+	}
+	return Pending, fmt.Errorf("unknown task state %q", name)
+}
+
+// MarshalText makes the API emit states by name, e.g. "Running" instead of 2.
+func (s State) MarshalText() ([]byte, error) {
+	if s < 0 || int(s) >= len(stateNames) {
+		return nil, fmt.Errorf("unknown task state %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	state, err := ParseState(string(text))
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// UnmarshalJSON accepts a state name as well as the numeric form older clients
+// and stored tasks use.
+func (s *State) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		return s.UnmarshalText([]byte(name))
+	}
+
+	n, err := strconv.Atoi(string(data))
+	if err != nil || n < 0 || n >= len(stateNames) {
+		return fmt.Errorf("invalid task state %s", data)
+	}
+	*s = State(n)
+	return nil
+}
+
 var stateTransitionMap = map[State][]State{
-	Pending:   []State{Scheduled},
-	Scheduled: []State{Scheduled, Running, Failed},
-	Running:   []State{Running, Completed, Failed},
-	Completed: []State{},
-	Failed:    []State{Scheduled},
+	Pending:    []State{Scheduled, Cancelled},
+	Scheduled:  []State{Scheduled, Running, Completed, Failed, Lost, Cancelled},
+	Running:    []State{Running, Stopping, Restarting, Completed, Failed, Lost},
+	Stopping:   []State{Stopping, Completed, Failed},
+	Restarting: []State{Restarting, Scheduled, Running, Failed},
+	Lost:       []State{Lost, Scheduled, Running, Completed, Failed},
+	Completed:  []State{Scheduled},
+	Failed:     []State{Scheduled, Restarting},
+	Cancelled:  []State{},
 }
 
 func Contains(states []State, state State) bool {
@@ -34,3 +105,111 @@ func Contains(states []State, state State) bool {
 func ValidateStateTransition(current State, next State) bool {
 	return Contains(stateTransitionMap[current], next)
 }
+
+// Reasons recorded with a transition, short and machine readable; the message
+// carries the details.
+const (
+	ReasonScheduled         = "Scheduled"
+	ReasonRejected          = "Rejected"
+	ReasonStarted           = "Started"
+	ReasonStartError        = "StartError"
+	ReasonInvalidSpec       = "InvalidSpec"
+	ReasonExited            = "Exited"
+	ReasonError             = "Error"
+	ReasonOOMKilled         = "OOMKilled"
+	ReasonContainerNotFound = "ContainerNotFound"
+	ReasonStopRequested     = "StopRequested"
+	ReasonStopped           = "Stopped"
+	ReasonStopError         = "StopError"
+	ReasonCancelled         = "Cancelled"
+	ReasonHealthCheckFailed = "HealthCheckFailed"
+	ReasonRestarted         = "Restarted"
+	ReasonConfigChanged     = "ConfigChanged"
+	ReasonWorkerUnreachable = "WorkerUnreachable"
+	ReasonWorkerReported    = "WorkerReported"
+)
+
+// maxTransitions is how many transitions are kept on a task, oldest dropped first.
+const maxTransitions = 10
+
+// Transition records a state change of a task and why it happened.
+type Transition struct {
+	From      State
+	To        State
+	Reason    string
+	Message   string
+	Timestamp time.Time
+}
+
+// Hook is called after every transition applied by a StateMachine.
+type Hook func(t *Task, tr Transition)
+
+// EventSink receives a TaskEvent for every transition; store.Store satisfies it.
+type EventSink interface {
+	Put(key string, value interface{}) error
+}
+
+// StateMachine applies task state transitions. It refuses the ones not allowed
+// by stateTransitionMap, records the others on the task, emits a TaskEvent and
+// runs the registered hooks, so metrics, webhooks or restart policies can follow
+// task state without hooking into the manager and worker loops.
+type StateMachine struct {
+	Events EventSink
+	mu     sync.Mutex
+	hooks  []Hook
+}
+
+func NewStateMachine(events EventSink) *StateMachine {
+	return &StateMachine{Events: events}
+}
+
+func (sm *StateMachine) AddHook(h Hook) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.hooks = append(sm.hooks, h)
+}
+
+// Transition moves t to next. Staying in the same state is a no-op; an invalid
+// transition returns an error and leaves the task untouched.
+func (sm *StateMachine) Transition(t *Task, next State, reason string, message string) error {
+	if t.State == next {
+		return nil
+	}
+	if !ValidateStateTransition(t.State, next) {
+		return fmt.Errorf("invalid transition from %v to %v for task %s", t.State, next, t.ID)
+	}
+
+	tr := Transition{
+		From:      t.State,
+		To:        next,
+		Reason:    reason,
+		Message:   message,
+		Timestamp: time.Now(),
+	}
+	t.State = next
+	t.UpdateReady()
+	t.Transitions = append(t.Transitions, tr)
+	if len(t.Transitions) > maxTransitions {
+		t.Transitions = t.Transitions[len(t.Transitions)-maxTransitions:]
+	}
+
+	if sm.Events != nil {
+		te := TaskEvent{
+			ID:        uuid.New(),
+			State:     next,
+			Timestamp: tr.Timestamp,
+			Task:      *t,
+		}
+		if err := sm.Events.Put(te.ID.String(), &te); err != nil {
+			log.Printf("error storing event for task %s: %v\n", t.ID, err)
+		}
+	}
+
+	sm.mu.Lock()
+	hooks := sm.hooks
+	sm.mu.Unlock()
+	for _, h := range hooks {
+		h(t, tr)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package task
+
+import (
+	"fmt"
+	"github.com/docker/docker/pkg/signal"
+	"syscall"
+	"time"
+)
+
+const defaultStopGracePeriod = 10 * time.Second
+
+// StopHook is a request sent to a task before it is stopped, an HTTP GET of Path on
+// Port (see Probe) or a Command run inside the task.
+type StopHook struct {
+	Type    string
+	Path    string
+	Port    int
+	Command []string
+}
+
+func (h StopHook) Validate() error {
+	switch h.Type {
+	case ProbeHTTP:
+		if h.Port < 0 || h.Port > 65535 {
+			return fmt.Errorf("invalid hook port %d", h.Port)
+		}
+	case ProbeExec:
+		if len(h.Command) == 0 {
+			return fmt.Errorf("exec hook has no command")
+		}
+	default:
+		return fmt.Errorf("unknown hook type %q, hooks are %q or %q", h.Type, ProbeHTTP, ProbeExec)
+	}
+	return nil
+}
+
+// Run sends the hook's request to the task, giving up after timeout.
+func (h StopHook) Run(t Task, r Runtime, timeout time.Duration) error {
+	return runHandler(t, r, h.Type, h.Path, h.Port, h.Command, timeout)
+}
+
+// GracePeriod is how long the task has to exit after its pre-stop hook was
+// sent and it received its stop signal, before it is killed.
+func (t Task) GracePeriod() time.Duration {
+	if t.StopGracePeriod > 0 {
+		return time.Duration(t.StopGracePeriod) * time.Second
+	}
+	return defaultStopGracePeriod
+}
+
+// GraceLeft returns the task with what is left of its grace period once its
+// pre-stop hook took elapsed, so the hook does not make the task take longer to
+// stop. At least a second is left for the task to handle its stop signal.
+func (t Task) GraceLeft(elapsed time.Duration) Task {
+	left := t.GracePeriod() - elapsed
+	t.StopGracePeriod = int64((left + time.Second - 1) / time.Second)
+	if t.StopGracePeriod < 1 {
+		t.StopGracePeriod = 1
+	}
+	return t
+}
+
+// ParseStopSignal returns the signal stopping the task, SIGTERM when it does
+// not name one. Names with or without the SIG prefix and numbers are accepted.
+func ParseStopSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return syscall.SIGTERM, nil
+	}
+	sig, err := signal.ParseSignal(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stop signal %q", name)
+	}
+	return sig, nil
+}
+
+// ValidateStop checks the task's stop settings.
+func ValidateStop(t Task) error {
+	if _, err := ParseStopSignal(t.StopSignal); err != nil {
+		return err
+	}
+	if t.StopGracePeriod < 0 {
+		return fmt.Errorf("StopGracePeriod must not be negative")
+	}
+	if t.PreStop != nil {
+		return t.PreStop.Validate()
+	}
+	return nil
+}
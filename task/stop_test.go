@@ -0,0 +1,27 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGraceLeft(t *testing.T) {
+	tests := []struct {
+		grace   int64
+		elapsed time.Duration
+		want    int64
+	}{
+		{grace: 0, elapsed: 0, want: 10},
+		{grace: 30, elapsed: 10 * time.Second, want: 20},
+		{grace: 30, elapsed: 10500 * time.Millisecond, want: 20},
+		{grace: 0, elapsed: 4 * time.Second, want: 6},
+		{grace: 5, elapsed: 5 * time.Second, want: 1},
+		{grace: 5, elapsed: time.Minute, want: 1},
+	}
+	for _, tt := range tests {
+		got := Task{StopGracePeriod: tt.grace}.GraceLeft(tt.elapsed).StopGracePeriod
+		if got != tt.want {
+			t.Errorf("grace %ds after %v: got %ds, want %ds", tt.grace, tt.elapsed, got, tt.want)
+		}
+	}
+}
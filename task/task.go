@@ -2,16 +2,20 @@ package task
 
 import (
 	"context"
+	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/google/uuid"
 	"io"
 	"log"
 	"math"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -24,68 +28,209 @@ import (
 
   - We also could remove HostPorts attribute since we are using 'host' mode for NetworkMode;
     but to keep things in consistent with the book, we keep it.
+
+  - An ExposedPort without a host port in PortBindings is published on a host port the manager
+    allocates from its port range on the task's worker. HostPorts records where each port is published.
+
+  - Driver selects the runtime that runs the task: "docker" (the default) or "exec",
+    which runs Entrypoint followed by Cmd as a plain process on the worker without pulling an image,
+    as the worker's user and with the worker's environment plus Env. Exec tasks do not survive a
+    restart of their worker, see ExecRuntime.
+
+  - Kind is either "service" (the default), a long-running task whose exit is always a failure,
+    or "batch", a run-to-completion task that is Completed when it exits with code 0.
+    ExitCode, OOMKilled and FinishTime record how the task's container ended.
+
+  - Mounts declares named volumes, bind mounts and tmpfs mounts. Bind mounts are only accepted for
+    host paths the worker allows. VolumeRetention ("delete", the default, or "retain") decides
+    whether the task's named volumes are removed along with its container.
+
+  - Secrets references secrets stored on the manager by name. Their values are only sent to the
+    worker when the task starts and never stored with the task, see SecretRef.
+
+  - Configs mounts configs stored on the manager into the task as read-only files, see ConfigRef.
+    ConfigVersions records the version of each config the task was last started with.
+
+  - ImagePullPolicy is "Always" (the default), "IfNotPresent" or "Never". Credentials for private
+    registries are stored encrypted on the manager and sent to the worker with each task event
+    that needs them; the worker only uses them for that task's pulls.
+
+  - Every resource field is enforced by the driver: Cpu, Memory (bytes), Disk (bytes, through the
+    storage driver's size option), PidsLimit, CpusetCpus, MemorySwap (memory plus swap, -1 for
+    unlimited) and Ulimits. A worker rejects a task asking for a limit it cannot enforce.
+
+  - LivenessProbe and ReadinessProbe are run by the worker that runs the task, see Probe, and their
+    outcome is reported in Liveness and Readiness. A failing liveness probe gets the task restarted,
+    a failing readiness probe only clears Ready, which takes the task out of load balancing and
+    discovery. HealthCheck is the older form of a liveness probe, an HTTP path on the lowest published port.
+
+  - RestartPolicy ("Never", "OnFailure", the default, or "Always") and Restart decide whether and
+    when the manager restarts the task after it failed, exited or its liveness probe failed; the
+    container itself is never restarted by docker. RestartCount counts the restarts in a row.
+
+  - Stopping a task sends its PreStop hook, then StopSignal (SIGTERM when empty), and kills it if it
+    has not exited StopGracePeriod seconds (10 when 0) later; the task is Stopping meanwhile.
+
+  - Containers turns the task into a group of containers run together on one worker, see Container.
+    Resource requests are summed over the group when picking a worker.
+
+  - Transitions keeps the latest state changes with the reason for each, see
+    StateMachine.
+
+  - Entrypoint and Cmd follow docker's semantics:
+    when they are empty, the image's own ENTRYPOINT and CMD are used.
 */
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	ExposedPort   nat.PortSet
-	PortBindings  nat.PortMap
-	NetworkMode   container.NetworkMode
-	RestartPolicy string
-	StartTime     time.Time
-	FinishTime    time.Time
-	HostPorts     nat.PortMap
-	HealthCheck   string
-	RestartCount  int
+	ID              uuid.UUID
+	ContainerID     string
+	Name            string
+	State           State
+	Kind            string
+	Driver          string
+	Image           string
+	ImagePullPolicy string
+	Entrypoint      []string
+	Cmd             []string
+	Env             []string
+	WorkingDir      string
+	Labels          map[string]string
+	User            string
+	Cpu             float64
+	Memory          int64
+	Disk            int64
+	PidsLimit       int64
+	CpusetCpus      string
+	MemorySwap      int64
+	Ulimits         []units.Ulimit
+	ExposedPort     nat.PortSet
+	PortBindings    nat.PortMap
+	NetworkMode     container.NetworkMode
+	Mounts          []Mount
+	Containers      []Container
+	Secrets         []SecretRef
+	Configs         []ConfigRef
+	ConfigVersions  map[string]int
+	VolumeRetention string
+	RestartPolicy   string
+	Restart         RestartConfig
+	StopSignal      string
+	StopGracePeriod int64
+	PreStop         *StopHook
+	StartTime       time.Time
+	FinishTime      time.Time
+	ExitCode        int
+	OOMKilled       bool
+	HostPorts       nat.PortMap
+	HealthCheck     string
+	LivenessProbe   *Probe
+	ReadinessProbe  *Probe
+	Liveness        ProbeStatus
+	Readiness       ProbeStatus
+	Ready           bool
+	RestartCount    int
+	Transitions     []Transition
+	// RegistryAuths is only set on the copy of the task the worker hands to the
+	// runtime to pull its images; it is never stored nor sent over the API.
+	RegistryAuths []RegistryAuth `json:"-"`
+}
+
+// DriverName returns the name of the driver that runs the task.
+func (t Task) DriverName() string {
+	if t.Driver == "" {
+		return "docker"
+	}
+	return t.Driver
 }
 
+// LastTransition returns the most recent state change of the task, the one that
+// explains its current state, or nil if none was recorded.
+func (t *Task) LastTransition() *Transition {
+	if len(t.Transitions) == 0 {
+		return nil
+	}
+	return &t.Transitions[len(t.Transitions)-1]
+}
+
+const (
+	KindService = "service"
+	KindBatch   = "batch"
+)
+
 type TaskEvent struct {
 	ID        uuid.UUID
 	State     State
 	Timestamp time.Time
 	Task      Task
+	// RegistryAuths, the credentials for the registries of the task's images, and
+	// Secrets and Configs, the values of the task's secrets and configs by name,
+	// are only set on events the manager sends to a worker, never on stored ones.
+	RegistryAuths []RegistryAuth    `json:",omitempty"`
+	Secrets       map[string]string `json:",omitempty"`
+	Configs       map[string]string `json:",omitempty"`
 }
 
 type Config struct {
-	Name          string
-	AttachStdin   bool
-	AttachStdout  bool
-	AttachStderr  bool
-	ExposedPort   nat.PortSet
-	PortBindings  nat.PortMap
-	NetworkMode   container.NetworkMode
-	Cmd           []string
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	Env           []string
-	RestartPolicy string
+	Name            string
+	AttachStdin     bool
+	AttachStdout    bool
+	AttachStderr    bool
+	ExposedPort     nat.PortSet
+	PortBindings    nat.PortMap
+	NetworkMode     container.NetworkMode
+	Entrypoint      []string
+	Cmd             []string
+	Image           string
+	ImagePullPolicy string
+	Cpu             float64
+	Memory          int64
+	Disk            int64
+	PidsLimit       int64
+	CpusetCpus      string
+	MemorySwap      int64
+	Ulimits         []units.Ulimit
+	Env             []string
+	WorkingDir      string
+	Labels          map[string]string
+	User            string
+	Mounts          []Mount
+	VolumeRetention string
+	StopSignal      string
+	StopTimeout     int
 }
 
 func NewConfig(t *Task) *Config {
 	return &Config{
-		Name:          t.Name,
-		ExposedPort:   t.ExposedPort,
-		PortBindings:  t.PortBindings,
-		Image:         t.Image,
-		Cpu:           t.Cpu,
-		Memory:        t.Memory,
-		Disk:          t.Disk,
-		RestartPolicy: t.RestartPolicy,
-		NetworkMode:   t.NetworkMode,
+		Name:            t.Name,
+		ExposedPort:     t.ExposedPort,
+		PortBindings:    t.PortBindings,
+		Image:           t.Image,
+		ImagePullPolicy: t.ImagePullPolicy,
+		Entrypoint:      t.Entrypoint,
+		Cmd:             t.Cmd,
+		Env:             t.Env,
+		WorkingDir:      t.WorkingDir,
+		Labels:          t.Labels,
+		User:            t.User,
+		Mounts:          t.Mounts,
+		VolumeRetention: t.VolumeRetention,
+		Cpu:             t.Cpu,
+		Memory:          t.Memory,
+		Disk:            t.Disk,
+		PidsLimit:       t.PidsLimit,
+		CpusetCpus:      t.CpusetCpus,
+		MemorySwap:      t.MemorySwap,
+		Ulimits:         t.Ulimits,
+		NetworkMode:     t.NetworkMode,
+		StopSignal:      t.StopSignal,
+		StopTimeout:     int(t.GracePeriod() / time.Second),
 	}
 }
 
 type Docker struct {
 	Client *client.Client
 	Config Config
+	// RegistryAuth is the encoded credentials for the image's registry, if any.
+	RegistryAuth string
 }
 
 func NewDocker(c *Config) *Docker {
@@ -101,6 +246,8 @@ type DockerResult struct {
 	Action      string
 	ContainerId string
 	Result      string
+	// GroupContainerIds are the ids of a task group's extra containers, in the order of Task.Containers.
+	GroupContainerIds []string
 }
 
 type DockerInspectResponse struct {
@@ -111,39 +258,66 @@ type DockerInspectResponse struct {
 func (d *Docker) Run() DockerResult {
 	ctx := context.Background()
 
-	reader, err := d.Client.ImagePull(
-		ctx,
-		d.Config.Image,
-		types.ImagePullOptions{},
-	)
+	if err := d.pullImage(ctx); err != nil {
+		return DockerResult{Error: err}
+	}
+
+	mounts, err := dockerMounts(d.Config.Mounts)
 	if err != nil {
-		log.Printf("Error pulling image %s: %v\n", d.Config.Image, err)
+		log.Printf("Error preparing mounts for container %s: %v\n", d.Config.Name, err)
 		return DockerResult{Error: err}
 	}
-	io.Copy(os.Stdout, reader)
 
-	rp := container.RestartPolicy{
-		Name: d.Config.RestartPolicy,
+	for _, m := range d.Config.Mounts {
+		if m.Type != MountVolume {
+			continue
+		}
+		_, err := d.Client.VolumeCreate(ctx, volume.VolumeCreateBody{
+			Name:   m.Source,
+			Labels: map[string]string{"cube.task": d.Config.Name},
+		})
+		if err != nil {
+			log.Printf("Error creating volume %s: %v\n", m.Source, err)
+			return DockerResult{Error: err}
+		}
 	}
 
 	r := container.Resources{
-		Memory:   d.Config.Memory,
-		NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
+		Memory:     d.Config.Memory,
+		NanoCPUs:   int64(d.Config.Cpu * math.Pow(10, 9)),
+		CpusetCpus: d.Config.CpusetCpus,
+		MemorySwap: d.Config.MemorySwap,
+	}
+	if d.Config.PidsLimit > 0 {
+		r.PidsLimit = &d.Config.PidsLimit
+	}
+	for i := range d.Config.Ulimits {
+		r.Ulimits = append(r.Ulimits, &d.Config.Ulimits[i])
 	}
 
 	cc := container.Config{
 		Image:        d.Config.Image,
+		Entrypoint:   d.Config.Entrypoint,
+		Cmd:          d.Config.Cmd,
 		Env:          d.Config.Env,
+		WorkingDir:   d.Config.WorkingDir,
+		Labels:       d.Config.Labels,
+		User:         d.Config.User,
 		ExposedPorts: d.Config.ExposedPort,
+		StopSignal:   d.Config.StopSignal,
+		StopTimeout:  &d.Config.StopTimeout,
 		Tty:          false,
 	}
 
 	hc := container.HostConfig{
-		RestartPolicy:   rp,
 		Resources:       r,
-		PublishAllPorts: true,
+		PublishAllPorts: !d.Config.NetworkMode.IsContainer(),
 		PortBindings:    d.Config.PortBindings,
 		NetworkMode:     d.Config.NetworkMode,
+		Mounts:          mounts,
+	}
+	if d.Config.Disk > 0 {
+		hc.StorageOpt = map[string]string{"size": strconv.FormatInt(d.Config.Disk, 10)}
 	}
 
 	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, d.Config.Name)
@@ -157,14 +331,6 @@ func (d *Docker) Run() DockerResult {
 		return DockerResult{Error: err}
 	}
 
-	out, err := d.Client.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
-	if err != nil {
-		log.Printf("Error getting logs for container %s: %v\n", resp.ID, err)
-		return DockerResult{Error: err}
-	}
-
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-
 	return DockerResult{
 		ContainerId: resp.ID,
 		Action:      "start",
@@ -172,19 +338,49 @@ func (d *Docker) Run() DockerResult {
 	}
 }
 
+// pullImage makes the image available locally according to the pull policy.
+func (d *Docker) pullImage(ctx context.Context) error {
+	policy := d.Config.ImagePullPolicy
+	if policy == PullNever || policy == PullIfNotPresent {
+		_, _, err := d.Client.ImageInspectWithRaw(ctx, d.Config.Image)
+		if err == nil {
+			return nil
+		}
+		if policy == PullNever {
+			log.Printf("Image %s is not present and pull policy is %s\n", d.Config.Image, policy)
+			return fmt.Errorf("image %s is not present on the worker and pull policy is %s", d.Config.Image, policy)
+		}
+	}
+
+	reader, err := d.Client.ImagePull(
+		ctx,
+		d.Config.Image,
+		types.ImagePullOptions{RegistryAuth: d.RegistryAuth},
+	)
+	if err != nil {
+		log.Printf("Error pulling image %s: %v\n", d.Config.Image, err)
+		return err
+	}
+	defer reader.Close()
+	io.Copy(os.Stdout, reader)
+	return nil
+}
+
 func (d *Docker) Stop(id string) DockerResult {
 	log.Printf("Attempting to stop container %v\n", id)
 	ctx := context.Background()
-	if err := d.Client.ContainerStop(ctx, id, nil); err != nil {
+	timeout := time.Duration(d.Config.StopTimeout) * time.Second
+	if err := d.Client.ContainerStop(ctx, id, &timeout); err != nil {
 		log.Printf("Error stopping container %s: %v\n", id, err)
 		return DockerResult{Error: err}
 	}
 
+	retain := d.Config.VolumeRetention == RetainVolumes
 	err := d.Client.ContainerRemove(
 		ctx,
 		id,
 		types.ContainerRemoveOptions{
-			RemoveVolumes: true,
+			RemoveVolumes: !retain,
 			RemoveLinks:   false,
 			Force:         false,
 		})
@@ -193,13 +389,24 @@ func (d *Docker) Stop(id string) DockerResult {
 		return DockerResult{Error: err}
 	}
 
+	// RemoveVolumes only covers anonymous volumes, named ones are removed here.
+	if !retain {
+		for _, m := range d.Config.Mounts {
+			if m.Type != MountVolume {
+				continue
+			}
+			if err := d.Client.VolumeRemove(ctx, m.Source, false); err != nil {
+				log.Printf("Error removing volume %s: %v\n", m.Source, err)
+			}
+		}
+	}
+
 	return DockerResult{Action: "stop", Result: "success", Error: nil}
 }
 
 func (d *Docker) Inspect(containerId string) DockerInspectResponse {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
 	ctx := context.Background()
-	resp, err := dc.ContainerInspect(ctx, containerId)
+	resp, err := d.Client.ContainerInspect(ctx, containerId)
 	if err != nil {
 		log.Printf("Error inspecting container %s: %v\n", containerId, err)
 		return DockerInspectResponse{Error: err}
@@ -207,3 +414,118 @@ func (d *Docker) Inspect(containerId string) DockerInspectResponse {
 
 	return DockerInspectResponse{Container: &resp}
 }
+
+// Logs returns the demultiplexed stdout and stderr of the container.
+func (d *Docker) Logs(containerId string, opts LogOptions) (io.ReadCloser, error) {
+	ctx := context.Background()
+	out, err := d.Client.ContainerLogs(ctx, containerId, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+	})
+	if err != nil {
+		log.Printf("Error getting logs for container %s: %v\n", containerId, err)
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, out)
+		out.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// Wait blocks until the container is no longer running.
+func (d *Docker) Wait(containerId string) error {
+	ctx := context.Background()
+	statusCh, errCh := d.Client.ContainerWait(ctx, containerId, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		log.Printf("Error waiting for container %s: %v\n", containerId, err)
+		return err
+	case <-statusCh:
+		return nil
+	}
+}
+
+// Exec starts a command inside the container and attaches to its standard streams.
+func (d *Docker) Exec(containerId string, opts ExecOptions) (ExecSession, error) {
+	ctx := context.Background()
+	exec, err := d.Client.ContainerExecCreate(ctx, containerId, types.ExecConfig{
+		Cmd:          opts.Cmd,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		log.Printf("Error creating exec in container %s: %v\n", containerId, err)
+		return nil, err
+	}
+
+	resp, err := d.Client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: opts.Tty})
+	if err != nil {
+		log.Printf("Error attaching to exec %s in container %s: %v\n", exec.ID, containerId, err)
+		return nil, err
+	}
+
+	if opts.Tty && opts.Height > 0 && opts.Width > 0 {
+		err = d.Client.ContainerExecResize(ctx, exec.ID, types.ResizeOptions{Height: opts.Height, Width: opts.Width})
+		if err != nil {
+			log.Printf("Error resizing tty of exec %s in container %s: %v\n", exec.ID, containerId, err)
+		}
+	}
+
+	return &dockerExecSession{resp: resp}, nil
+}
+
+// RunCommand runs a command inside the container without attaching to it and
+// returns its exit code once it finished. When ctx is done first the command is
+// abandoned: docker cannot stop an exec, and the pid it reports is not one the
+// worker can safely kill when it runs in a container itself.
+func (d *Docker) RunCommand(ctx context.Context, containerId string, cmd []string) (int, error) {
+	exec, err := d.Client.ContainerExecCreate(ctx, containerId, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return 0, err
+	}
+	err = d.Client.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true})
+	for err == nil && ctx.Err() == nil {
+		var resp types.ContainerExecInspect
+		resp, err = d.Client.ContainerExecInspect(ctx, exec.ID)
+		if err == nil && !resp.Running {
+			return resp.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if ctx.Err() == nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%v did not finish in time", cmd)
+}
+
+type dockerExecSession struct {
+	resp types.HijackedResponse
+}
+
+func (s *dockerExecSession) Read(b []byte) (int, error) {
+	return s.resp.Reader.Read(b)
+}
+
+func (s *dockerExecSession) Write(b []byte) (int, error) {
+	return s.resp.Conn.Write(b)
+}
+
+func (s *dockerExecSession) CloseWrite() error {
+	return s.resp.CloseWrite()
+}
+
+func (s *dockerExecSession) Close() error {
+	return s.resp.Conn.Close()
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+)
+
+// StreamResponse copies r to w, flushing after every write so that a client
+// following a stream (e.g. task logs) sees the data as soon as it is produced.
+func StreamResponse(w http.ResponseWriter, r io.Reader) error {
+	flusher, ok := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if ok {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// UpgradeProtocol is the protocol a connection is switched to for interactive
+// streams such as exec sessions: the raw bytes, like docker's attach endpoints.
+const UpgradeProtocol = "tcp"
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// upgradedConn reads through the buffered reader used while parsing the HTTP
+// handshake, so bytes that arrived together with it are not lost.
+type upgradedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *upgradedConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+// CloseWrite half-closes c if it supports it, signalling the end of the input.
+func CloseWrite(c interface{}) error {
+	if cw, ok := c.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// DialUpgrade sends a POST for path to host asking to switch protocols and
+// returns the raw connection once the server answers with 101.
+func DialUpgrade(host string, path string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s%s", host, path), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", UpgradeProtocol)
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		conn.Close()
+		return nil, fmt.Errorf("unexpected response from %s (%d): %s", host, resp.StatusCode, body)
+	}
+
+	return &upgradedConn{Conn: conn, r: br}, nil
+}
+
+// Upgrade takes over the connection behind w and answers the client's upgrade
+// request. From then on the caller owns the raw connection.
+func Upgrade(w http.ResponseWriter) (net.Conn, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection does not support hijacking")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", UpgradeProtocol)
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &upgradedConn{Conn: conn, r: rw.Reader}, nil
+}
+
+// Splice copies the client's input to upstream and upstream's output back to
+// the client. It returns once upstream has no more output.
+func Splice(client io.ReadWriter, upstream io.ReadWriter) {
+	go func() {
+		io.Copy(upstream, client)
+		CloseWrite(upstream)
+	}()
+	io.Copy(client, upstream)
+}
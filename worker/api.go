@@ -26,8 +26,16 @@ func (a *Api) initRouter() {
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
 			r.Get("/", a.InspectTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
 		})
 	})
+	a.Router.Route("/capabilities", func(r chi.Router) {
+		r.Get("/", a.GetCapabilitiesHandler)
+	})
+	a.Router.Route("/info", func(r chi.Router) {
+		r.Get("/", a.GetInfoHandler)
+	})
 	a.Router.Route("/stats", func(r chi.Router) {
 		r.Get("/", a.GetStatsHandler)
 		r.Get("/cpu-usage/{interval}", a.GetCpuUsageHandler)
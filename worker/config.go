@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"cube/task"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SetConfigs keeps the data of the task's configs, sent by the manager with the
+// task, until the task is started.
+func (w *Worker) SetConfigs(id uuid.UUID, data map[string]string) {
+	w.configsMu.Lock()
+	defer w.configsMu.Unlock()
+	if w.configs == nil {
+		w.configs = make(map[uuid.UUID]map[string]string)
+	}
+	w.configs[id] = data
+}
+
+// withConfigs returns a copy of t with its configs written to files in the
+// task's configs directory and bind mounted read-only at the paths the task
+// asks for.
+func (w *Worker) withConfigs(t task.Task) (task.Task, error) {
+	if len(t.Configs) == 0 {
+		return t, nil
+	}
+
+	w.configsMu.Lock()
+	data := w.configs[t.ID]
+	delete(w.configs, t.ID)
+	w.configsMu.Unlock()
+
+	run := t
+	run.Mounts = append([]task.Mount(nil), t.Mounts...)
+	dir := filepath.Join(w.ConfigsDir, t.ID.String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return t, err
+	}
+	for i, ref := range t.Configs {
+		value, ok := data[ref.Name]
+		if !ok {
+			w.removeConfigs(t)
+			return t, fmt.Errorf("config %s was not sent with the task", ref.Name)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d", i))
+		if err := os.WriteFile(file, []byte(value), 0444); err != nil {
+			w.removeConfigs(t)
+			return t, err
+		}
+		run.Mounts = append(run.Mounts, task.Mount{Type: task.MountBind, Source: file, Target: ref.File, ReadOnly: true})
+	}
+	return run, nil
+}
+
+// removeConfigs removes the config files of a task that no longer runs.
+func (w *Worker) removeConfigs(t task.Task) {
+	if len(t.Configs) == 0 || w.ConfigsDir == "" {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(w.ConfigsDir, t.ID.String())); err != nil {
+		log.Printf("Error removing the configs of task %v: %v\n", t.ID, err)
+	}
+}
@@ -1,8 +1,10 @@
 package worker
 
 import (
+	"cube/node"
 	"cube/stats"
 	"cube/task"
+	"cube/utils"
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi/v5"
@@ -29,6 +31,27 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := a.Worker.ValidateTask(te.Task); err != nil {
+		msg := fmt.Sprintf("Invalid task %v: %v", te.Task.ID, err)
+		log.Println(msg)
+		w.WriteHeader(http.StatusBadRequest)
+		e := ErrResponse{
+			HttpStatusCode: http.StatusBadRequest,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	if len(te.RegistryAuths) > 0 {
+		a.Worker.SetRegistryAuths(te.Task.ID, te.RegistryAuths)
+	}
+	if len(te.Secrets) > 0 {
+		a.Worker.SetSecrets(te.Task.ID, te.Secrets)
+	}
+	if len(te.Configs) > 0 {
+		a.Worker.SetConfigs(te.Task.ID, te.Configs)
+	}
 	a.Worker.AddTask(te.Task)
 	log.Printf("Added task: %v\n", te.Task.ID)
 	w.WriteHeader(http.StatusCreated)
@@ -46,6 +69,7 @@ func (a *Api) InspectTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if taskID == "" {
 		log.Printf("No taskID passed in request.\n")
 		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	tID, _ := uuid.Parse(taskID)
@@ -56,12 +80,137 @@ func (a *Api) InspectTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := a.Worker.InspectTask(t.(task.Task))
+	tk := *t.(*task.Task)
+	resp := a.Worker.InspectTask(tk)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(resp.Container)
+	if resp.Container != nil {
+		json.NewEncoder(w).Encode(redactSecrets(resp.Container, tk))
+		return
+	}
+	json.NewEncoder(w).Encode(resp.State)
+}
 
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("Error parsing taskID: %s %v\n", taskID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	t, err := a.Worker.Db.Get(tID.String())
+	if err != nil {
+		log.Printf("No task with ID %v found", tID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	follow, _ := strconv.ParseBool(q.Get("follow"))
+	opts := task.LogOptions{
+		Follow:    follow,
+		Tail:      q.Get("tail"),
+		Since:     q.Get("since"),
+		Container: q.Get("container"),
+	}
+
+	logs, err := a.Worker.TaskLogs(*t.(*task.Task), opts)
+	if err != nil {
+		msg := fmt.Sprintf("Error getting logs for task %v: %v", tID, err)
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusInternalServerError,
+			Message:        msg,
+		})
+		return
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := utils.StreamResponse(w, logs); err != nil {
+		log.Printf("Error streaming logs for task %v: %v\n", tID, err)
+	}
+}
+
+// ExecTaskHandler runs a command inside the task's container. The connection is
+// upgraded to a raw stream carrying the command's stdin, stdout and stderr.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("Error parsing taskID: %s %v\n", taskID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.Worker.Db.Get(tID.String())
+	if err != nil {
+		log.Printf("No task with ID %v found", tID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	t := *result.(*task.Task)
+
+	q := r.URL.Query()
+	tty, _ := strconv.ParseBool(q.Get("tty"))
+	stdin, _ := strconv.ParseBool(q.Get("stdin"))
+	height, _ := strconv.ParseUint(q.Get("h"), 10, 16)
+	width, _ := strconv.ParseUint(q.Get("w"), 10, 16)
+	opts := task.ExecOptions{
+		Cmd:       q["cmd"],
+		Tty:       tty,
+		Stdin:     stdin,
+		Container: q.Get("container"),
+		Height:    uint(height),
+		Width:     uint(width),
+	}
+
+	var status int
+	var msg string
+	switch {
+	case len(opts.Cmd) == 0:
+		status, msg = http.StatusBadRequest, "No command passed in request"
+	case t.State != task.Running:
+		status, msg = http.StatusConflict, fmt.Sprintf("Task %v is not running", tID)
+	}
+	if status != 0 {
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrResponse{HttpStatusCode: status, Message: msg})
+		return
+	}
+
+	session, err := a.Worker.ExecTask(t, opts)
+	if err != nil {
+		msg := fmt.Sprintf("Error executing %v in task %v: %v", opts.Cmd, tID, err)
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusInternalServerError,
+			Message:        msg,
+		})
+		return
+	}
+	defer session.Close()
+
+	conn, err := utils.Upgrade(w)
+	if err != nil {
+		log.Printf("Error upgrading exec connection for task %v: %v\n", tID, err)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("Executing %v in task %v\n", opts.Cmd, tID)
+	utils.Splice(conn, session)
+	log.Printf("Exec session %v in task %v ended\n", opts.Cmd, tID)
 }
 
 func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -70,18 +219,21 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if taskID == "" {
 		log.Printf("No taskID passed in the request.\n")
 		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	tID, err := uuid.Parse(taskID)
 	if err != nil {
 		log.Printf("Error parsing taskID: %s %v\n", taskID, err)
 		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	t, err := a.Worker.Db.Get(tID.String())
 	if err != nil {
 		log.Printf("No task with ID %v found", tID)
 		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
 	// copy the task t so we change the state
@@ -115,3 +267,27 @@ func (a *Api) GetCpuUsageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(&usage)
 }
+
+// GetCapabilitiesHandler answers with 503 while a driver cannot tell its
+// capabilities, so the manager keeps the ones it had and asks again later.
+func (a *Api) GetCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	caps, err := a.Worker.Capabilities()
+	if err != nil {
+		log.Printf("Error getting capabilities: %v\n", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ErrResponse{
+			HttpStatusCode: http.StatusServiceUnavailable,
+			Message:        err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(caps)
+}
+
+func (a *Api) GetInfoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.Info{Name: a.Worker.Name, Address: a.Worker.Address})
+}
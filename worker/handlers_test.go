@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"cube/task"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInspectTaskHandlerRedactsSecrets(t *testing.T) {
+	w, _ := newTestWorker(t)
+	tk := newTask()
+	tk.Env = []string{"MODE=production"}
+	tk.Secrets = []task.SecretRef{{Name: "db", Env: "DB_PASSWORD"}}
+	w.SetSecrets(tk.ID, map[string]string{"db": "hunter2"})
+	startTask(t, w, tk)
+
+	api := Api{Worker: w}
+	api.initRouter()
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+tk.ID.String(), nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "hunter2") {
+		t.Errorf("inspect response contains the secret value: %s", body)
+	}
+	for _, want := range []string{"DB_PASSWORD=[redacted]", "MODE=production"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("inspect response %s does not contain %q", body, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"cube/task"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretsDir is where the worker writes the secret files of its tasks, on tmpfs
+// where there is one so they never reach the disk.
+func secretsDir(name string) string {
+	base := os.TempDir()
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		base = "/dev/shm"
+	}
+	return filepath.Join(base, fmt.Sprintf("cube_secrets_%s", name))
+}
+
+// SetSecrets keeps the values of the task's secrets, sent by the manager with
+// the task, until the task is started.
+func (w *Worker) SetSecrets(id uuid.UUID, values map[string]string) {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	if w.secrets == nil {
+		w.secrets = make(map[uuid.UUID]map[string]string)
+	}
+	w.secrets[id] = values
+}
+
+// forgetSecrets drops the values of the task's secrets when the task will not
+// start, so they are not kept in memory until it is sent again.
+func (w *Worker) forgetSecrets(id uuid.UUID) {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	delete(w.secrets, id)
+}
+
+// SetRegistryAuths keeps the credentials sent with the task for the registries of
+// its images until the task is started.
+func (w *Worker) SetRegistryAuths(id uuid.UUID, auths []task.RegistryAuth) {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	if w.registryAuths == nil {
+		w.registryAuths = make(map[uuid.UUID][]task.RegistryAuth)
+	}
+	w.registryAuths[id] = auths
+}
+
+// takeRegistryAuths returns the registry credentials sent with the task and
+// forgets them, they are only used for the pulls of the start at hand.
+func (w *Worker) takeRegistryAuths(id uuid.UUID) []task.RegistryAuth {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	auths := w.registryAuths[id]
+	delete(w.registryAuths, id)
+	return auths
+}
+
+// withSecrets returns the task to hand to the runtime: a copy of t with its
+// secrets added to the environment and bind mounted read-only from files in the
+// task's secrets directory. The values are forgotten once written, so neither
+// the stored task nor the worker's memory keeps them.
+func (w *Worker) withSecrets(t task.Task) (task.Task, error) {
+	if len(t.Secrets) == 0 {
+		return t, nil
+	}
+
+	w.secretsMu.Lock()
+	values := w.secrets[t.ID]
+	delete(w.secrets, t.ID)
+	w.secretsMu.Unlock()
+
+	run := t
+	run.Env = append([]string(nil), t.Env...)
+	run.Mounts = append([]task.Mount(nil), t.Mounts...)
+	dir := filepath.Join(w.SecretsDir, t.ID.String())
+	for i, ref := range t.Secrets {
+		value, ok := values[ref.Name]
+		if !ok {
+			w.removeSecrets(t)
+			return t, fmt.Errorf("value of secret %s was not sent with the task", ref.Name)
+		}
+		if ref.Env != "" {
+			run.Env = append(run.Env, fmt.Sprintf("%s=%s", ref.Env, value))
+		}
+		if ref.File == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return t, err
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d", i))
+		if err := os.WriteFile(file, []byte(value), 0444); err != nil {
+			w.removeSecrets(t)
+			return t, err
+		}
+		run.Mounts = append(run.Mounts, task.Mount{Type: task.MountBind, Source: file, Target: ref.File, ReadOnly: true})
+	}
+	return run, nil
+}
+
+// removeSecrets removes the secret files of a task that no longer runs.
+func (w *Worker) removeSecrets(t task.Task) {
+	if len(t.Secrets) == 0 || w.SecretsDir == "" {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(w.SecretsDir, t.ID.String())); err != nil {
+		log.Printf("Error removing the secrets of task %v: %v\n", t.ID, err)
+	}
+}
+
+// redactSecrets returns a copy of the container's inspect response with the
+// values of the task's env secrets replaced, the environment docker reports is
+// the one withSecrets added them to.
+func redactSecrets(c *types.ContainerJSON, t task.Task) *types.ContainerJSON {
+	if c.Config == nil || len(t.Secrets) == 0 {
+		return c
+	}
+	secret := make(map[string]bool)
+	for _, ref := range t.Secrets {
+		if ref.Env != "" {
+			secret[ref.Env] = true
+		}
+	}
+
+	redacted := *c
+	config := *c.Config
+	config.Env = make([]string, len(c.Config.Env))
+	for i, kv := range c.Config.Env {
+		name, _, _ := strings.Cut(kv, "=")
+		if secret[name] {
+			kv = name + "=[redacted]"
+		}
+		config.Env[i] = kv
+	}
+	redacted.Config = &config
+	return &redacted
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"cube/store"
+	"cube/task"
 	"fmt"
 	"github.com/golang-collections/collections/queue"
 	"strconv"
@@ -14,9 +15,13 @@ func ServeWorkerWithApi(host string, port int) {
 
 	fmt.Println("Starting Cube worker")
 
+	es := store.NewInMemoryStore[task.TaskEvent]("task event")
 	w := Worker{
-		Queue: *queue.New(),
-		Db:    store.NewInMemoryTaskStore(),
+		Queue:        *queue.New(),
+		Db:           store.NewInMemoryStore[task.Task]("task"),
+		EventDb:      es,
+		Runtime:      newRuntime("worker"),
+		StateMachine: task.NewStateMachine(es),
 	}
 	api := Api{
 		Address: host,
@@ -27,6 +32,7 @@ func ServeWorkerWithApi(host string, port int) {
 	go w.RunTasks()
 	go w.CollectStats()
 	go w.UpdateTasks()
+	go w.RunProbes()
 	api.Start()
 }
 
@@ -47,6 +53,7 @@ func ServeWorkersWithApi(hostPortMap map[int]string, dbType string) {
 		go w.RunTasks()
 		go w.CollectStats()
 		go w.UpdateTasks()
+		go w.RunProbes()
 		go api.Start()
 		idx++
 	}
@@ -71,6 +78,7 @@ func ServeWorkersByAddressWithApi(workers []string, dbType string) {
 		go w.RunTasks()
 		go w.CollectStats()
 		go w.UpdateTasks()
+		go w.RunProbes()
 		go api.Start()
 	}
 }
@@ -7,7 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,29 +24,85 @@ type Worker struct {
 	Db        store.Store
 	TaskCount int
 	Stats     *stats.Stats
+	Runtime   task.Runtime
+	// EventDb records a TaskEvent for every state change of the worker's tasks.
+	EventDb store.Store
+	// StateMachine applies every state change the worker makes to its tasks.
+	StateMachine *task.StateMachine
+	// Address is the IP address the worker advertises to the manager as the one
+	// its tasks are reached at; empty to leave it to the manager.
+	Address string
+	// BindPaths are the host paths tasks may bind mount, including anything below them.
+	BindPaths []string
+	// SecretsDir holds the files of the secrets mounted into tasks.
+	SecretsDir string
+	secrets    map[uuid.UUID]map[string]string
+	// registryAuths holds the registry credentials sent with a task until it starts.
+	registryAuths map[uuid.UUID][]task.RegistryAuth
+	secretsMu     sync.Mutex
+	// ConfigsDir holds the files of the configs mounted into tasks.
+	ConfigsDir string
+	configs    map[uuid.UUID]map[string]string
+	configsMu  sync.Mutex
+	// stopping holds the tasks being stopped in the background.
+	stopping   map[uuid.UUID]bool
+	stoppingMu sync.Mutex
+	// probing holds the tasks whose probes are running.
+	probing   map[uuid.UUID]bool
+	probingMu sync.Mutex
+	// tasksMu serialises the read-modify-write of stored tasks. A stored task is
+	// never changed in place, an updated copy replaces it.
+	tasksMu sync.Mutex
 }
 
 func New(name string, taskDbType string) *Worker {
 	w := Worker{
-		Name:  name,
-		Queue: *queue.New(),
+		Name:       name,
+		Queue:      *queue.New(),
+		Runtime:    newRuntime(name),
+		SecretsDir: secretsDir(name),
+		ConfigsDir: filepath.Join(os.TempDir(), fmt.Sprintf("cube_configs_%s", name)),
 	}
 	var s store.Store
+	var es store.Store
 	var err error
 	switch taskDbType {
 	case "memory":
-		s = store.NewInMemoryTaskStore()
+		s = store.NewInMemoryStore[task.Task]("task")
+		es = store.NewInMemoryStore[task.TaskEvent]("task event")
 	case "persistent":
 		filename := fmt.Sprintf("%s_tasks.db", name)
-		s, err = store.NewTaskStore(filename, 0600, "tasks")
+		s, err = store.NewJSONStore[task.Task](filename, 0600, "tasks", "task")
+		if err != nil {
+			log.Printf("eunable to create new task store: %v", err)
+		}
+		es, err = store.NewJSONStore[task.TaskEvent](fmt.Sprintf("%s_events.db", name), 0600, "events", "event")
 	}
 	if err != nil {
-		log.Printf("eunable to create new task store: %v", err)
+		log.Printf("unable to create new task event store: %v", err)
+		es = nil
 	}
 	w.Db = s
+	w.EventDb = es
+	w.StateMachine = task.NewStateMachine(es)
 	return &w
 }
 
+// newRuntime returns the drivers a worker can run tasks with. Without a usable
+// docker client the worker only offers the exec driver.
+func newRuntime(name string) task.Runtime {
+	drivers := task.Drivers{
+		"exec": task.NewExecRuntime(filepath.Join(os.TempDir(), fmt.Sprintf("cube_%s", name))),
+	}
+	d, err := task.NewDockerRuntime()
+	if err != nil {
+		log.Printf("Docker driver disabled: %v\n", err)
+		return drivers
+	}
+	drivers["docker"] = d
+	return drivers
+}
+
 func (w *Worker) GetTasks() []*task.Task {
 	taskList, err := w.Db.List()
 	if err != nil {
@@ -88,84 +151,376 @@ func (w *Worker) runTask() task.DockerResult {
 	taskQueued := t.(task.Task)
 	fmt.Printf("[worker] Found task in queue: %v:\n", taskQueued)
 
-	err := w.Db.Put(taskQueued.ID.String(), &taskQueued)
-	if err != nil {
-		msg := fmt.Errorf("error storing task %s: %v", taskQueued.ID.String(), err)
-		log.Println(msg)
-		return task.DockerResult{Error: msg}
-	}
-
+	// a task the worker has not seen before starts out as Pending
+	taskPersisted := task.Task{State: task.Pending}
 	result, err := w.Db.Get(taskQueued.ID.String())
-	if err != nil {
-		msg := fmt.Errorf("error getting task %s from database: %v", taskQueued.ID.String(), err)
-		log.Println(msg)
-		return task.DockerResult{Error: msg}
+	if err == nil {
+		taskPersisted = *result.(*task.Task)
 	}
 
-	taskPersisted := *result.(*task.Task)
+	// stopping an ended task keeps its state, only the container it left behind goes
+	if taskQueued.State == task.Completed && (taskPersisted.State == task.Failed || taskPersisted.State == task.Completed) {
+		return w.removeContainer(taskPersisted)
+	}
 
-	if taskPersisted.State == task.Completed {
-		return w.StopTask(taskPersisted)
+	if !task.ValidateStateTransition(taskPersisted.State, taskQueued.State) {
+		msg := fmt.Errorf("invalid transition from %v to %v for task %s", taskPersisted.State, taskQueued.State, taskQueued.ID)
+		log.Println(msg)
+		return task.DockerResult{Error: msg}
 	}
 
 	var dockerResult task.DockerResult
-	if task.ValidateStateTransition(taskPersisted.State, taskQueued.State) {
-		switch taskQueued.State {
-		case task.Scheduled:
-			dockerResult = w.StartTask(taskQueued)
-		case task.Completed:
-			dockerResult = w.StopTask(taskQueued)
-		default:
-			dockerResult.Error = errors.New("we should not get here")
-		}
-	} else {
-		dockerResult.Error = fmt.Errorf("invalid transition from %v to %v", taskPersisted.State, taskQueued.State)
+	switch taskQueued.State {
+	case task.Scheduled:
+		// a task restarted after it ended leaves the container of its previous run behind
+		if taskPersisted.ContainerID != "" && (taskPersisted.State == task.Failed || taskPersisted.State == task.Completed) {
+			if result := w.Runtime.Stop(taskPersisted); result.Error != nil {
+				log.Printf("Error removing previous container of task %v: %v\n", taskQueued.ID, result.Error)
+			}
+		}
+		if err := w.Db.Put(taskQueued.ID.String(), &taskQueued); err != nil {
+			msg := fmt.Errorf("error storing task %s: %v", taskQueued.ID.String(), err)
+			log.Println(msg)
+			return task.DockerResult{Error: msg}
+		}
+		dockerResult = w.StartTask(taskQueued)
+	case task.Restarting:
+		// the worker knows best which container currently runs the task
+		taskQueued.ContainerID = taskPersisted.ContainerID
+		taskQueued.Containers = taskPersisted.Containers
+		dockerResult = w.RestartTask(taskQueued)
+	case task.Completed:
+		if taskPersisted.State == task.Stopping {
+			log.Printf("Task %v is already stopping\n", taskQueued.ID)
+			return task.DockerResult{}
+		}
+		// the task is stopped from the state it is in, Completed is where it ends up
+		taskQueued.State = taskPersisted.State
+		dockerResult = w.StopTask(taskQueued)
+	default:
+		dockerResult.Error = errors.New("we should not get here")
 	}
 	return dockerResult
 }
 
 func (w *Worker) StartTask(t task.Task) task.DockerResult {
+	if err := w.ValidateTask(t); err != nil {
+		log.Printf("Err validating task %v: %v\n", t.ID, err)
+		w.transition(&t, task.Failed, task.ReasonInvalidSpec, err.Error())
+		return task.DockerResult{Error: err}
+	}
+
 	t.StartTime = time.Now()
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
-	result := d.Run()
+	t.Liveness = task.ProbeStatus{}
+	t.Readiness = task.ProbeStatus{}
+	run, err := w.withConfigs(t)
+	if err == nil {
+		run, err = w.withSecrets(run)
+	}
+	run.RegistryAuths = w.takeRegistryAuths(t.ID)
+	if err != nil {
+		log.Printf("Err writing the configs and secrets of task %v: %v\n", t.ID, err)
+		w.forgetSecrets(t.ID)
+		w.removeSecrets(t)
+		w.removeConfigs(t)
+		w.transition(&t, task.Failed, task.ReasonStartError, err.Error())
+		return task.DockerResult{Error: err}
+	}
+	result := w.Runtime.Run(run)
 	if result.Error != nil {
 		log.Printf("Err running task %v: %v\n", t.ID, result.Error)
-		t.State = task.Failed
-		w.Db.Put(t.ID.String(), &t)
+		w.removeSecrets(t)
+		w.removeConfigs(t)
+		w.transition(&t, task.Failed, task.ReasonStartError, result.Error.Error())
 		return result
 	}
 
 	t.ContainerID = result.ContainerId
-	t.State = task.Running
-	w.Db.Put(t.ID.String(), &t)
+	t.Containers = append([]task.Container(nil), t.Containers...)
+	for i, id := range result.GroupContainerIds {
+		t.Containers[i].ID = id
+	}
+	w.transition(&t, task.Running, task.ReasonStarted, fmt.Sprintf("started container %s", t.ContainerID))
 
 	return result
 }
 
+// Capabilities reports, for each driver, which resource limits it can enforce.
+// A driver that cannot tell is left out and makes the error non-nil.
+func (w *Worker) Capabilities() (map[string]task.Capabilities, error) {
+	drivers, ok := w.Runtime.(task.Drivers)
+	if !ok {
+		drivers = task.Drivers{"docker": w.Runtime}
+	}
+
+	caps := make(map[string]task.Capabilities)
+	var errs []string
+	for name, r := range drivers {
+		c, ok := r.(task.CapabilityReporter)
+		if !ok {
+			caps[name] = task.Capabilities{}
+			continue
+		}
+		driverCaps, err := c.Capabilities()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("driver %s: %v", name, err))
+			continue
+		}
+		caps[name] = driverCaps
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return caps, errors.New(strings.Join(errs, "; "))
+	}
+	return caps, nil
+}
+
+// ValidateTask checks that the task only asks for what this worker can provide.
+func (w *Worker) ValidateTask(t task.Task) error {
+	if !task.ValidPullPolicy(t.ImagePullPolicy) {
+		return fmt.Errorf("unknown image pull policy %q", t.ImagePullPolicy)
+	}
+	for _, m := range t.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := task.CheckBindMounts(t.Mounts, w.BindPaths); err != nil {
+		return err
+	}
+	if !task.ValidVolumeRetention(t.VolumeRetention) {
+		return fmt.Errorf("unknown volume retention %q", t.VolumeRetention)
+	}
+	if err := task.ValidateContainers(t); err != nil {
+		return err
+	}
+	if !task.ValidRestartPolicy(t.RestartPolicy) {
+		return fmt.Errorf("unknown restart policy %q", t.RestartPolicy)
+	}
+	if err := t.Restart.Validate(); err != nil {
+		return err
+	}
+	if err := task.ValidateStop(t); err != nil {
+		return err
+	}
+	for _, ref := range t.Secrets {
+		if err := ref.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := task.ValidateConfigs(t); err != nil {
+		return err
+	}
+	for _, p := range []*task.Probe{t.LivenessCheck(), t.ReadinessProbe} {
+		if p == nil {
+			continue
+		}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if t.DriverName() == "exec" {
+		if err := task.ValidateExecTask(t); err != nil {
+			return err
+		}
+	}
+
+	all, err := w.Capabilities()
+	caps, ok := all[t.DriverName()]
+	if !ok && err != nil {
+		return fmt.Errorf("cannot tell which limits driver %q enforces: %v", t.DriverName(), err)
+	}
+	if !ok {
+		return fmt.Errorf("unknown driver %q", t.DriverName())
+	}
+	return caps.Check(t)
+}
+
+// StopTask moves the task to Stopping and stops it in the background, so the
+// worker goes on with other tasks while the task uses its grace period. The task
+// is Completed once it is stopped.
 func (w *Worker) StopTask(t task.Task) task.DockerResult {
-	conf := task.NewConfig(&t)
-	d := task.NewDocker(conf)
+	w.transition(&t, task.Stopping, task.ReasonStopRequested, "")
+	w.stopInBackground(t)
+	return task.DockerResult{Action: "stop", Result: "stopping"}
+}
+
+// stopInBackground stops the Stopping task in a goroutine, which updateTasks
+// knows about: a task left Stopping without one is stopped again.
+func (w *Worker) stopInBackground(t task.Task) {
+	w.stoppingMu.Lock()
+	if w.stopping == nil {
+		w.stopping = make(map[uuid.UUID]bool)
+	}
+	if w.stopping[t.ID] {
+		w.stoppingMu.Unlock()
+		return
+	}
+	w.stopping[t.ID] = true
+	w.stoppingMu.Unlock()
+
+	go func() {
+		defer func() {
+			w.stoppingMu.Lock()
+			delete(w.stopping, t.ID)
+			w.stoppingMu.Unlock()
+		}()
+
+		// a container that is already gone, e.g. stopped before the worker
+		// restarted, leaves nothing to stop
+		if resp := w.InspectTask(t); resp.State != nil {
+			result := w.stopGracefully(t)
+			if result.Error != nil {
+				log.Printf("Error stopping container %v: %v", t.ContainerID, result.Error)
+				w.transition(&t, task.Failed, task.ReasonStopError, result.Error.Error())
+				return
+			}
+		} else {
+			w.removeSecrets(t)
+			w.removeConfigs(t)
+		}
+		t.FinishTime = time.Now()
+		w.transition(&t, task.Completed, task.ReasonStopped, "")
+		log.Printf("Stopped and removed container %v for task %v\n", t.ContainerID, t.ID)
+	}()
+}
+
+func (w *Worker) stopInProgress(id uuid.UUID) bool {
+	w.stoppingMu.Lock()
+	defer w.stoppingMu.Unlock()
+	return w.stopping[id]
+}
+
+// stopGracefully sends the task's pre-stop hook, then has the runtime stop it
+// with its stop signal and what the hook left of its grace period. A failing
+// hook does not keep the task from being stopped.
+func (w *Worker) stopGracefully(t task.Task) task.DockerResult {
+	if t.PreStop != nil {
+		log.Printf("Running the pre-stop hook of task %v\n", t.ID)
+		start := time.Now()
+		if err := t.PreStop.Run(t, w.Runtime, t.GracePeriod()); err != nil {
+			log.Printf("Pre-stop hook of task %v failed: %v\n", t.ID, err)
+		}
+		t = t.GraceLeft(time.Since(start))
+	}
+	result := w.Runtime.Stop(t)
+	w.removeSecrets(t)
+	w.removeConfigs(t)
+	return result
+}
 
-	result := d.Stop(t.ContainerID)
+// removeContainer removes the container, secrets and configs an ended task left
+// behind.
+func (w *Worker) removeContainer(t task.Task) task.DockerResult {
+	if t.ContainerID == "" {
+		return task.DockerResult{Action: "stop", Result: "success"}
+	}
+	result := w.Runtime.Stop(t)
 	if result.Error != nil {
-		log.Printf("Error stopping container %v: %v", t.ContainerID, result.Error)
-		//t.State = task.Failed
-		//w.Db[t.ID] = &t
+		log.Printf("Error removing container %v of task %v: %v\n", t.ContainerID, t.ID, result.Error)
 		return result
 	}
-	t.FinishTime = time.Now()
-	t.State = task.Completed
-	w.Db.Put(t.ID.String(), &t)
-	log.Printf("Stopped and removed container %v for task %v\n", t.ContainerID, t.ID)
-
+	w.updateTask(t.ID, func(current *task.Task) bool {
+		current.ContainerID = ""
+		current.Containers = nil
+		return true
+	})
+	w.removeSecrets(t)
+	w.removeConfigs(t)
 	return result
 }
 
-func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
-	conf := task.NewConfig(&t)
-	d := task.NewDocker(conf)
-	return d.Inspect(t.ContainerID)
+// RestartTask replaces the task's container with a new one.
+func (w *Worker) RestartTask(t task.Task) task.DockerResult {
+	w.transition(&t, task.Restarting, task.ReasonRestarted, "")
+
+	if t.ContainerID != "" {
+		result := w.stopGracefully(t)
+		if result.Error != nil {
+			log.Printf("Error stopping container %v of restarting task %v: %v\n", t.ContainerID, t.ID, result.Error)
+		}
+	}
+	t.ContainerID = ""
+	t.Containers = append([]task.Container(nil), t.Containers...)
+	for i := range t.Containers {
+		t.Containers[i].ID = ""
+	}
+
+	return w.StartTask(t)
+}
+
+// transition moves the task to the next state through the state machine and
+// stores a copy of it. The transition is dropped when the stored task is no
+// longer in the state t was read in, e.g. a stop finished while the task was
+// being inspected.
+func (w *Worker) transition(t *task.Task, next task.State, reason string, message string) {
+	w.tasksMu.Lock()
+	defer w.tasksMu.Unlock()
+
+	if t.State != next {
+		if result, err := w.Db.Get(t.ID.String()); err == nil {
+			if current := result.(*task.Task).State; current != t.State {
+				log.Printf("Task %v moved from %v to %v meanwhile, not moving it to %v\n", t.ID, t.State, current, next)
+				return
+			}
+		}
+	}
+	if err := w.StateMachine.Transition(t, next, reason, message); err != nil {
+		log.Printf("Error changing state of task %v: %v\n", t.ID, err)
+		return
+	}
+	stored := *t
+	w.Db.Put(t.ID.String(), &stored)
+}
+
+// updateTask applies f to a copy of the stored task and stores the copy, unless
+// f returns false. It returns the task as stored.
+func (w *Worker) updateTask(id uuid.UUID, f func(t *task.Task) bool) (task.Task, bool) {
+	w.tasksMu.Lock()
+	defer w.tasksMu.Unlock()
+
+	result, err := w.Db.Get(id.String())
+	if err != nil {
+		return task.Task{}, false
+	}
+	t := *result.(*task.Task)
+	if !f(&t) {
+		return t, false
+	}
+	w.Db.Put(id.String(), &t)
+	return t, true
+}
+
+// stopGroup stops the containers of an exited task group that still run, so the
+// group ends as a unit.
+func (w *Worker) stopGroup(t *task.Task) {
+	if len(t.Containers) == 0 {
+		return
+	}
+	g, ok := w.Runtime.(task.GroupStopper)
+	if !ok {
+		return
+	}
+	if err := g.StopGroup(*t); err != nil {
+		log.Printf("Error stopping the containers of task group %s: %v\n", t.ID, err)
+	}
+}
+
+func (w *Worker) InspectTask(t task.Task) task.InspectResponse {
+	return w.Runtime.Inspect(t)
+}
+
+func (w *Worker) TaskLogs(t task.Task, opts task.LogOptions) (io.ReadCloser, error) {
+	return w.Runtime.Logs(t, opts)
+}
+
+func (w *Worker) ExecTask(t task.Task, opts task.ExecOptions) (task.ExecSession, error) {
+	e, ok := w.Runtime.(task.Executor)
+	if !ok {
+		return nil, errors.New("runtime does not support exec")
+	}
+	return e.Exec(t, opts)
 }
 
 func (w *Worker) UpdateTasks() {
@@ -184,28 +539,155 @@ func (w *Worker) updateTasks() {
 		log.Printf("error getting list of tasks: %v\n", err)
 		return
 	}
-	for _, t := range tasks.([]*task.Task) {
+	for _, stored := range tasks.([]*task.Task) {
+		// the stored task is shared with other goroutines, updates go to a copy
+		current := *stored
+		t := &current
+		if t.State == task.Stopping && !w.stopInProgress(t.ID) {
+			log.Printf("Task %s is stopping but nothing stops it, stopping it again\n", t.ID)
+			w.stopInBackground(*t)
+			continue
+		}
 		if t.State == task.Running {
 			resp := w.InspectTask(*t)
 			if resp.Error != nil {
 				fmt.Printf("ERROR: %v\n", resp.Error)
 			}
 
-			if resp.Container == nil {
+			if resp.State == nil {
 				log.Printf("No container for running task %s\n", t.ID)
-				t.State = task.Failed
-				w.Db.Put(t.ID.String(), t)
+				w.removeSecrets(*t)
+				w.removeConfigs(*t)
+				w.transition(t, task.Failed, task.ReasonContainerNotFound, fmt.Sprintf("container %s not found", t.ContainerID))
+				continue
 			}
 
-			if resp.Container.State.Status == "exited" {
-				log.Printf("Container for task %s in non-running state %s\n", t.ID, resp.Container.State.Status)
-				t.State = task.Failed
-				w.Db.Put(t.ID.String(), t)
+			if resp.State.Status == "exited" {
+				log.Printf("Container for task %s in non-running state %s\n", t.ID, resp.State.Status)
+				t.ExitCode = resp.State.ExitCode
+				t.OOMKilled = resp.State.OOMKilled
+				t.FinishTime = resp.State.FinishedAt
+				msg := fmt.Sprintf("exited with code %d", t.ExitCode)
+				w.stopGroup(t)
+				w.removeSecrets(*t)
+				w.removeConfigs(*t)
+				switch {
+				case t.OOMKilled:
+					log.Printf("Task %s was killed for running out of memory\n", t.ID)
+					w.transition(t, task.Failed, task.ReasonOOMKilled, msg)
+				case t.Kind == task.KindBatch && t.ExitCode == 0:
+					log.Printf("Batch task %s completed successfully\n", t.ID)
+					w.transition(t, task.Completed, task.ReasonExited, msg)
+				default:
+					log.Printf("Task %s exited with code %d\n", t.ID, t.ExitCode)
+					w.transition(t, task.Failed, task.ReasonError, msg)
+				}
+				continue
 			}
 
 			// task is running, update exposed ports
-			t.HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
-			w.Db.Put(t.ID.String(), t)
+			w.updateTask(t.ID, func(current *task.Task) bool {
+				if current.State != task.Running {
+					return false
+				}
+				current.HostPorts = resp.State.HostPorts
+				return true
+			})
+		}
+	}
+}
+
+// RunProbes runs the liveness and readiness probes of the worker's running
+// tasks as they come due and records their outcome in the tasks, which the
+// manager collects along with their state.
+func (w *Worker) RunProbes() {
+	for {
+		w.runProbes(time.Now())
+		time.Sleep(time.Second)
+	}
+}
+
+// runProbes starts the probes that are due, each task's in a goroutine of its
+// own, so a probe waiting for its timeout does not hold up those of other tasks.
+// A task whose probes still run from an earlier round is skipped.
+func (w *Worker) runProbes(now time.Time) {
+	tasks, err := w.Db.List()
+	if err != nil {
+		log.Printf("error getting list of tasks: %v\n", err)
+		return
+	}
+	for _, t := range tasks.([]*task.Task) {
+		if t.State != task.Running {
+			continue
+		}
+		liveness := t.LivenessCheck()
+		if liveness != nil && !liveness.Due(*t, t.Liveness, now) {
+			liveness = nil
+		}
+		readiness := t.ReadinessProbe
+		if readiness != nil && !readiness.Due(*t, t.Readiness, now) {
+			readiness = nil
+		}
+		if (liveness == nil && readiness == nil) || !w.startProbing(t.ID) {
+			continue
+		}
+
+		go func(t task.Task) {
+			defer w.stopProbing(t.ID)
+			if liveness != nil {
+				w.probeTask(&t, "liveness", *liveness, now, func(t *task.Task) *task.ProbeStatus { return &t.Liveness })
+			}
+			if readiness != nil {
+				w.probeTask(&t, "readiness", *readiness, now, func(t *task.Task) *task.ProbeStatus { return &t.Readiness })
+			}
+		}(*t)
+	}
+}
+
+// startProbing marks the task's probes as running, it returns false if they
+// already are.
+func (w *Worker) startProbing(id uuid.UUID) bool {
+	w.probingMu.Lock()
+	defer w.probingMu.Unlock()
+	if w.probing[id] {
+		return false
+	}
+	if w.probing == nil {
+		w.probing = make(map[uuid.UUID]bool)
+	}
+	w.probing[id] = true
+	return true
+}
+
+func (w *Worker) stopProbing(id uuid.UUID) {
+	w.probingMu.Lock()
+	defer w.probingMu.Unlock()
+	delete(w.probing, id)
+}
+
+// probeTask runs one probe of the task and records the outcome in the status
+// returned by status.
+func (w *Worker) probeTask(t *task.Task, kind string, p task.Probe, now time.Time, status func(*task.Task) *task.ProbeStatus) {
+	err := p.Check(*t, w.Runtime)
+	if err != nil {
+		log.Printf("The %s probe of task %s failed: %v\n", kind, t.ID, err)
+	}
+
+	// the task may have changed while the probe ran
+	current, ok := w.updateTask(t.ID, func(current *task.Task) bool {
+		if current.State != task.Running || !current.StartTime.Equal(t.StartTime) {
+			return false
+		}
+		s := status(current)
+		previous := s.Status
+		s.Record(p, err, now)
+		if s.Status != previous {
+			log.Printf("The %s probe of task %s is now %s\n", kind, t.ID, s.Status)
 		}
+		current.UpdateReady()
+		return true
+	})
+	if ok {
+		*t = current
 	}
 }
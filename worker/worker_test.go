@@ -0,0 +1,467 @@
+package worker
+
+import (
+	"cube/store"
+	"cube/task"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+)
+
+func newTestWorker(t *testing.T) (*Worker, *task.FakeRuntime) {
+	t.Helper()
+	rt := task.NewFakeRuntime()
+	es := store.NewInMemoryStore[task.TaskEvent]("task event")
+	w := &Worker{
+		Name:         "test",
+		Queue:        *queue.New(),
+		Db:           store.NewInMemoryStore[task.Task]("task"),
+		EventDb:      es,
+		Runtime:      rt,
+		StateMachine: task.NewStateMachine(es),
+		SecretsDir:   t.TempDir(),
+		ConfigsDir:   t.TempDir(),
+	}
+	return w, rt
+}
+
+// storedTask returns the task as the worker stored it.
+func storedTask(t *testing.T, w *Worker, id uuid.UUID) *task.Task {
+	t.Helper()
+	result, err := w.Db.Get(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.(*task.Task)
+}
+
+// waitForState waits for the stored task to reach state s.
+func waitForState(t *testing.T, w *Worker, id uuid.UUID, s task.State) *task.Task {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		tk := storedTask(t, w, id)
+		if tk.State == s {
+			return tk
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task is %v, want %v", tk.State, s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTask() task.Task {
+	return task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+}
+
+// startTask runs the task through the worker's queue.
+func startTask(t *testing.T, w *Worker, tk task.Task) *task.Task {
+	t.Helper()
+	w.AddTask(tk)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	return waitForState(t, w, tk.ID, task.Running)
+}
+
+func TestStopTask(t *testing.T) {
+	w, rt := newTestWorker(t)
+	tk := startTask(t, w, newTask())
+
+	stop := *tk
+	stop.State = task.Completed
+	w.AddTask(stop)
+	w.runTask()
+
+	waitForState(t, w, tk.ID, task.Completed)
+	if resp := rt.Inspect(*tk); resp.State != nil {
+		t.Errorf("container of the stopped task still exists: %+v", resp.State)
+	}
+}
+
+func TestUpdateTasksFinishesStuckStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+	}{
+		{name: "container still running", running: true},
+		{name: "container already gone", running: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker(t)
+			tk := startTask(t, w, newTask())
+			if !tt.running {
+				rt.Stop(*tk)
+			}
+			// a worker that restarted while stopping the task left it Stopping
+			w.StateMachine.Transition(tk, task.Stopping, task.ReasonStopRequested, "")
+			w.Db.Put(tk.ID.String(), tk)
+
+			w.updateTasks()
+			waitForState(t, w, tk.ID, task.Completed)
+			if resp := rt.Inspect(*tk); resp.State != nil {
+				t.Errorf("container of the stopped task still exists: %+v", resp.State)
+			}
+		})
+	}
+}
+
+func TestStartTaskFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		task     func() task.Task
+		runError error
+		reason   string
+	}{
+		{
+			name:   "invalid spec",
+			task:   func() task.Task { tk := newTask(); tk.RestartPolicy = "Sometimes"; return tk },
+			reason: task.ReasonInvalidSpec,
+		},
+		{
+			name:   "unknown volume retention",
+			task:   func() task.Task { tk := newTask(); tk.VolumeRetention = "keep"; return tk },
+			reason: task.ReasonInvalidSpec,
+		},
+		{
+			name: "exec task with a file secret",
+			task: func() task.Task {
+				tk := newTask()
+				tk.Driver, tk.Cmd = "exec", []string{"true"}
+				tk.Secrets = []task.SecretRef{{Name: "db", File: "/run/secrets/db"}}
+				return tk
+			},
+			reason: task.ReasonInvalidSpec,
+		},
+		{
+			name: "exec task with a config",
+			task: func() task.Task {
+				tk := newTask()
+				tk.Driver, tk.Cmd = "exec", []string{"true"}
+				tk.Configs = []task.ConfigRef{{Name: "nginx", File: "/etc/nginx/nginx.conf"}}
+				return tk
+			},
+			reason: task.ReasonInvalidSpec,
+		},
+		{
+			name:   "unknown driver",
+			task:   func() task.Task { tk := newTask(); tk.Driver = "vm"; return tk },
+			reason: task.ReasonInvalidSpec,
+		},
+		{
+			name:     "runtime error",
+			task:     newTask,
+			runError: errors.New("no such image"),
+			reason:   task.ReasonStartError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker(t)
+			rt.RunError = tt.runError
+			tk := tt.task()
+			w.AddTask(tk)
+			if result := w.runTask(); result.Error == nil {
+				t.Fatal("runTask succeeded, want an error")
+			}
+
+			got := storedTask(t, w, tk.ID)
+			if got.State != task.Failed {
+				t.Fatalf("task is %v, want %v", got.State, task.Failed)
+			}
+			if tr := got.LastTransition(); tr.Reason != tt.reason {
+				t.Errorf("task failed with reason %s, want %s", tr.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestStartTaskForgetsSecretsWhenConfigsFail(t *testing.T) {
+	w, _ := newTestWorker(t)
+	tk := newTask()
+	tk.Secrets = []task.SecretRef{{Name: "db", Env: "DB_PASSWORD"}}
+	tk.Configs = []task.ConfigRef{{Name: "nginx", File: "/etc/nginx/nginx.conf"}}
+	// the config is not sent with the task
+	w.SetSecrets(tk.ID, map[string]string{"db": "hunter2"})
+	w.AddTask(tk)
+	if result := w.runTask(); result.Error == nil {
+		t.Fatal("runTask succeeded without the task's config")
+	}
+
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	if _, ok := w.secrets[tk.ID]; ok {
+		t.Error("the worker still holds the secrets of the task that failed to start")
+	}
+}
+
+func TestUpdateTasksRecordsExits(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		exitCode int
+		gone     bool
+		state    task.State
+		reason   string
+	}{
+		{name: "batch task succeeds", kind: task.KindBatch, exitCode: 0, state: task.Completed, reason: task.ReasonExited},
+		{name: "batch task fails", kind: task.KindBatch, exitCode: 1, state: task.Failed, reason: task.ReasonError},
+		{name: "service exits", kind: task.KindService, exitCode: 0, state: task.Failed, reason: task.ReasonError},
+		{name: "container removed", kind: task.KindService, gone: true, state: task.Failed, reason: task.ReasonContainerNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker(t)
+			tk := newTask()
+			tk.Kind = tt.kind
+			running := startTask(t, w, tk)
+			if tt.gone {
+				rt.Stop(*running)
+			} else {
+				rt.Exit(running.ContainerID, tt.exitCode)
+			}
+
+			w.updateTasks()
+			got := storedTask(t, w, tk.ID)
+			if got.State != tt.state {
+				t.Fatalf("task is %v, want %v", got.State, tt.state)
+			}
+			if tr := got.LastTransition(); tr.Reason != tt.reason {
+				t.Errorf("task ended with reason %s, want %s", tr.Reason, tt.reason)
+			}
+			if !tt.gone && got.ExitCode != tt.exitCode {
+				t.Errorf("exit code %d, want %d", got.ExitCode, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestTransitionsRecordEvents(t *testing.T) {
+	w, rt := newTestWorker(t)
+	tk := newTask()
+	tk.Kind = task.KindBatch
+	running := startTask(t, w, tk)
+	rt.Exit(running.ContainerID, 0)
+	w.updateTasks()
+
+	result, err := w.EventDb.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := map[task.State]bool{}
+	for _, te := range result.([]*task.TaskEvent) {
+		if te.Task.ID != tk.ID || te.Task.State != te.State {
+			t.Errorf("event %+v does not carry the task in its new state", te)
+		}
+		recorded[te.State] = true
+	}
+	for _, s := range []task.State{task.Running, task.Completed} {
+		if !recorded[s] {
+			t.Errorf("no event recorded for the transition to %v", s)
+		}
+	}
+}
+
+func TestRestartTaskReplacesContainer(t *testing.T) {
+	w, rt := newTestWorker(t)
+	tk := startTask(t, w, newTask())
+
+	restart := *tk
+	restart.State = task.Restarting
+	restart.ContainerID = ""
+	w.AddTask(restart)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	got := storedTask(t, w, tk.ID)
+	if got.State != task.Running {
+		t.Fatalf("task is %v, want %v", got.State, task.Running)
+	}
+	if got.ContainerID == "" || got.ContainerID == tk.ContainerID {
+		t.Errorf("task runs in container %q, want a new one", got.ContainerID)
+	}
+	if resp := rt.Inspect(*tk); resp.State != nil {
+		t.Errorf("the old container still exists: %+v", resp.State)
+	}
+}
+
+func TestRunTaskRejectsInvalidTransition(t *testing.T) {
+	w, _ := newTestWorker(t)
+	tk := startTask(t, w, newTask())
+
+	again := *tk
+	again.State = task.Pending
+	w.AddTask(again)
+	if result := w.runTask(); result.Error == nil {
+		t.Error("runTask accepted moving a running task back to Pending")
+	}
+	if got := storedTask(t, w, tk.ID); got.State != task.Running {
+		t.Errorf("task is %v, want it left %v", got.State, task.Running)
+	}
+}
+
+func TestStartTaskForgetsRegistryAuths(t *testing.T) {
+	w, _ := newTestWorker(t)
+	tk := newTask()
+	w.SetRegistryAuths(tk.ID, []task.RegistryAuth{{Server: task.DefaultRegistry, Username: "cube", Password: "hunter2"}})
+	running := startTask(t, w, tk)
+
+	if len(running.RegistryAuths) != 0 {
+		t.Errorf("stored task carries registry credentials")
+	}
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	if _, ok := w.registryAuths[tk.ID]; ok {
+		t.Errorf("worker still keeps the registry credentials of the started task")
+	}
+}
+
+func TestRunProbesDoesNotWaitForSlowTask(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	w, _ := newTestWorker(t)
+	probed := func(srv *httptest.Server) *task.Task {
+		u, _ := url.Parse(srv.URL)
+		_, port, _ := net.SplitHostPort(u.Host)
+		tk := &task.Task{
+			ID:            uuid.New(),
+			State:         task.Running,
+			StartTime:     time.Now().Add(-time.Minute),
+			HostPorts:     nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}},
+			LivenessProbe: &task.Probe{Type: task.ProbeHTTP, Path: "/", TimeoutSeconds: 5},
+		}
+		w.Db.Put(tk.ID.String(), tk)
+		return tk
+	}
+	slowTask := probed(slow)
+	fastTask := probed(fast)
+
+	start := time.Now()
+	w.runProbes(time.Now())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("runProbes took %v, want it to leave the probes running", elapsed)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for storedTask(t, w, fastTask.ID).Liveness.ConsecutiveSuccesses == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the probe of the fast task did not finish while the slow one hangs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the slow task's probe still runs, a later round does not start another one
+	if w.startProbing(slowTask.ID) {
+		t.Error("the probe of the slow task is not marked as running")
+	}
+	if got := storedTask(t, w, slowTask.ID).Liveness.LastProbeTime; !got.IsZero() {
+		t.Errorf("the slow probe recorded an outcome at %v before its server answered", got)
+	}
+}
+
+func TestProbesDoNotUndoTransitions(t *testing.T) {
+	// run with -race: probes record their outcome while the worker updates and
+	// ends the task
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	for i := 0; i < 10; i++ {
+		w, rt := newTestWorker(t)
+		tk := newTask()
+		tk.Kind = task.KindBatch
+		tk.PortBindings = nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}}
+		tk.LivenessProbe = &task.Probe{Type: task.ProbeHTTP, Path: "/"}
+		running := startTask(t, w, tk)
+
+		done := make(chan struct{})
+		probing := make(chan struct{})
+		go func() {
+			defer close(probing)
+			now := time.Now()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				now = now.Add(time.Hour)
+				w.runProbes(now)
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for storedTask(t, w, tk.ID).Liveness.ConsecutiveSuccesses < 3 {
+			if time.Now().After(deadline) {
+				t.Fatal("the probes did not run")
+			}
+			w.updateTasks()
+			time.Sleep(time.Millisecond)
+		}
+		if i%2 == 0 {
+			rt.Exit(running.ContainerID, 0)
+			w.updateTasks()
+		} else {
+			stop := *running
+			stop.State = task.Completed
+			w.AddTask(stop)
+			w.runTask()
+			waitForState(t, w, tk.ID, task.Completed)
+		}
+		close(done)
+		<-probing
+		for !w.startProbing(tk.ID) {
+			time.Sleep(time.Millisecond)
+		}
+
+		if got := storedTask(t, w, tk.ID).State; got != task.Completed {
+			t.Fatalf("round %d: task is %v after the probes ran, want %v", i, got, task.Completed)
+		}
+	}
+}
+
+func TestCapabilitiesErrorIsNotCached(t *testing.T) {
+	w, rt := newTestWorker(t)
+	rt.CapabilitiesError = errors.New("cannot connect to the docker daemon")
+	api := Api{Worker: w}
+
+	rec := httptest.NewRecorder()
+	api.GetCapabilitiesHandler(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d while the driver cannot tell, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	tk := newTask()
+	tk.Memory = 1 << 20
+	if err := w.ValidateTask(tk); err == nil {
+		t.Error("task with a memory limit accepted while the driver cannot tell")
+	}
+
+	rt.CapabilitiesError = nil
+	rec = httptest.NewRecorder()
+	api.GetCapabilitiesHandler(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d once the driver answers, want %d", rec.Code, http.StatusOK)
+	}
+	if err := w.ValidateTask(tk); err != nil {
+		t.Errorf("task rejected once the driver answers: %v", err)
+	}
+}